@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"gopkg.in/ldap.v2"
+)
+
+// searchField поле расширенного поиска
+type searchField struct {
+	Attribute string
+	Title     string
+}
+
+// Поля расширенного поиска
+var searchFields = []searchField{
+	{"cn", "ФИО"},
+	{"title", "Должность"},
+	{"ou", "Отдел"},
+	{"o", "Организация"},
+	{"l", "Город"},
+	{"telephoneNumber", "Телефон"},
+	{"mail", "Email"},
+	{"postalAddress", "Адрес"},
+}
+
+// Способы сравнения значения поля
+const (
+	matchContains = iota
+	matchStarts
+	matchEquals
+)
+
+// Названия способов сравнения в порядке констант
+var matchTitles = []string{"содержит", "начинается с", "равно"}
+
+// searchCriterion условие расширенного поиска по одному полю
+type searchCriterion struct {
+	Attribute string
+	Match     int
+	Value     string
+}
+
+// filter формирует условие LDAP фильтра с экранированным значением
+func (c searchCriterion) filter() string {
+	value := escapeFilter(c.Value)
+	switch c.Match {
+	case matchStarts:
+		return "(" + c.Attribute + "=" + value + "*)"
+	case matchEquals:
+		return "(" + c.Attribute + "=" + value + ")"
+	}
+	return "(" + c.Attribute + "=*" + value + "*)"
+}
+
+// criteriaFilter объединяет условия через И или, если anyOf, через ИЛИ
+func criteriaFilter(criteria []searchCriterion, anyOf bool) string {
+	if len(criteria) == 1 {
+		return criteria[0].filter()
+	}
+	op := "&"
+	if anyOf {
+		op = "|"
+	}
+	filter := "(" + op
+	for _, c := range criteria {
+		filter += c.filter()
+	}
+	return filter + ")"
+}
+
+// advancedSearch ищет записи по фильтру во всех источниках или только в узле
+// дерева источника src. При useCache поиск выполняется в локальном кэше
+// сотрудников по тем же правилам, что и на LDAP сервере
+func advancedSearch(filter string, src *source, node *OrgNode, useCache bool) ([]LDAPEntry, error) {
+	base := ""
+	if node != nil {
+		filter = "(&" + filter + node.Filter + ")"
+		base = node.Base
+	}
+
+	if !useCache {
+		if src == nil {
+			return fetchPeople(filter)
+		}
+		return fetchEntriesFrom([]*source{src}, base, ldap.ScopeWholeSubtree, "(&"+entryFilter()+filter+")")
+	}
+
+	packet, err := ldap.CompileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := cachedPeople(false)
+	if err != nil {
+		return nil, err
+	}
+	var result []LDAPEntry
+	for _, entry := range entries {
+		if src != nil && entry.Source != src.Name {
+			continue
+		}
+		if !inScope(normalizeDN(entry.DN), normalizeDN(base), ldap.ScopeWholeSubtree) {
+			continue
+		}
+		if matchFilter(cacheEntry(entry), packet) {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+// cacheEntry создает запись каталога из записи локального кэша
+// для проверки по фильтру
+func cacheEntry(entry LDAPEntry) *ldap.Entry {
+	attributes := map[string][]string{}
+	for name, value := range entry.Attributes {
+		attributes[name] = []string{value}
+	}
+	for _, name := range []string{"cn", "sn", "givenName", "initials", "mail", "telephoneNumber", "title", "ou", "o", "l", "postalAddress"} {
+		if value := entryAttribute(entry, name); value != "" {
+			attributes[name] = []string{value}
+		}
+	}
+	return ldap.NewEntry(entry.DN, attributes)
+}
+
+// showAdvancedSearch открывает окно расширенного поиска.
+// Результаты показываются в таблице главного окна
+func showAdvancedSearch() {
+	window, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	if err != nil {
+		fmt.Printf("Ошибка создания окна: %v\n", err)
+		return
+	}
+	window.SetTitle("Расширенный поиск")
+	window.SetTransientFor(mainWindow)
+
+	box, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 5)
+	if err != nil {
+		fmt.Printf("Ошибка создания контейнера: %v\n", err)
+		return
+	}
+	box.SetMarginTop(10)
+	box.SetMarginBottom(10)
+	box.SetMarginStart(10)
+	box.SetMarginEnd(10)
+
+	grid, err := gtk.GridNew()
+	if err != nil {
+		fmt.Printf("Ошибка создания таблицы условий: %v\n", err)
+		return
+	}
+	grid.SetRowSpacing(5)
+	grid.SetColumnSpacing(5)
+
+	matchCombos := make([]*gtk.ComboBoxText, len(searchFields))
+	valueEntries := make([]*gtk.Entry, len(searchFields))
+	for i, field := range searchFields {
+		label, err := gtk.LabelNew(field.Title)
+		if err != nil {
+			fmt.Printf("Ошибка создания метки: %v\n", err)
+			return
+		}
+		label.SetHAlign(gtk.ALIGN_START)
+
+		combo, err := gtk.ComboBoxTextNew()
+		if err != nil {
+			fmt.Printf("Ошибка создания списка: %v\n", err)
+			return
+		}
+		for _, title := range matchTitles {
+			combo.AppendText(title)
+		}
+		combo.SetActive(matchContains)
+
+		entry, err := gtk.EntryNew()
+		if err != nil {
+			fmt.Printf("Ошибка создания поля ввода: %v\n", err)
+			return
+		}
+		entry.SetHExpand(true)
+
+		grid.Attach(label, 0, i, 1, 1)
+		grid.Attach(combo, 1, i, 1, 1)
+		grid.Attach(entry, 2, i, 1, 1)
+		matchCombos[i] = combo
+		valueEntries[i] = entry
+	}
+
+	anyCombo, err := gtk.ComboBoxTextNew()
+	if err != nil {
+		fmt.Printf("Ошибка создания списка: %v\n", err)
+		return
+	}
+	anyCombo.AppendText("Все условия (И)")
+	anyCombo.AppendText("Любое условие (ИЛИ)")
+	anyCombo.SetActive(0)
+
+	nodeCheck, err := gtk.CheckButtonNewWithLabel("Только в выбранном узле дерева")
+	if err != nil {
+		fmt.Printf("Ошибка создания флажка: %v\n", err)
+		return
+	}
+	cacheCheck, err := gtk.CheckButtonNewWithLabel("Искать в локальном кэше")
+	if err != nil {
+		fmt.Printf("Ошибка создания флажка: %v\n", err)
+		return
+	}
+	cacheCheck.SetTooltipText("Поиск без обращения к серверу по сохраненному списку сотрудников")
+
+	buttons, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 5)
+	if err != nil {
+		fmt.Printf("Ошибка создания контейнера: %v\n", err)
+		return
+	}
+	findButton, err := gtk.ButtonNewWithLabel("Найти")
+	if err != nil {
+		fmt.Printf("Ошибка создания кнопки: %v\n", err)
+		return
+	}
+	closeButton, err := gtk.ButtonNewWithLabel("Закрыть")
+	if err != nil {
+		fmt.Printf("Ошибка создания кнопки: %v\n", err)
+		return
+	}
+	buttons.PackEnd(closeButton, false, false, 0)
+	buttons.PackEnd(findButton, false, false, 0)
+
+	box.PackStart(grid, false, false, 0)
+	box.PackStart(anyCombo, false, false, 0)
+	box.PackStart(nodeCheck, false, false, 0)
+	box.PackStart(cacheCheck, false, false, 0)
+	box.PackStart(buttons, false, false, 0)
+	window.Add(box)
+
+	search := func() {
+		var criteria []searchCriterion
+		for i, field := range searchFields {
+			value, _ := valueEntries[i].GetText()
+			if value = strings.TrimSpace(value); value != "" {
+				criteria = append(criteria, searchCriterion{Attribute: field.Attribute, Match: matchCombos[i].GetActive(), Value: value})
+			}
+		}
+		if len(criteria) == 0 {
+			showErrorDialog("Заполните хотя бы одно условие")
+			return
+		}
+		filter := criteriaFilter(criteria, anyCombo.GetActive() == 1)
+
+		var src *source
+		var node *OrgNode
+		if nodeCheck.GetActive() {
+			src, node = selectedTreeNode()
+			if src == nil || !node.searchable() {
+				showErrorDialog("Выберите отдел в дереве")
+				return
+			}
+		}
+		if config.Debug {
+			fmt.Println(filter)
+		}
+
+		useCache := cacheCheck.GetActive()
+		go func() {
+			entries, err := advancedSearch(filter, src, node, useCache)
+			glib.IdleAdd(func() {
+				if err != nil {
+					showErrorDialog(err.Error())
+					return
+				}
+				showPeople(entries)
+			})
+		}()
+	}
+	findButton.Connect("clicked", search)
+	for _, entry := range valueEntries {
+		entry.Connect("activate", search)
+	}
+	closeButton.Connect("clicked", window.Destroy)
+
+	window.ShowAll()
+}
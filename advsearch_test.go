@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAdvancedSearchCacheNode(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	file := filepath.Join(dir, "people.ldif")
+	data := `dn: cn=Иванов Иван,dc=example
+objectClass: inetOrgPerson
+cn: Иванов Иван
+departmentNumber: 101
+title: Инженер
+
+dn: cn=Петров Петр,dc=example
+objectClass: inetOrgPerson
+cn: Петров Петр
+departmentNumber: 102
+title: Инженер
+`
+	if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	directory, err := newDirectory(SourceConfig{Backend: backendLDIF, DataFile: file, BaseDN: "dc=example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &source{Name: "test", BaseDN: "dc=example", dir: directory, treePathAttributes: []string{"departmentNumber"}}
+	saved := sources
+	sources = []*source{src}
+	defer func() { sources = saved }()
+
+	root, err := src.fetchOrgTree(false, groupingOrg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := root.Children["101"]
+	if node == nil {
+		t.Fatal("нет узла 101")
+	}
+
+	// В узле по атрибуту дерева ищется и на сервере, и в локальном кэше
+	for _, useCache := range []bool{false, true} {
+		entries, err := advancedSearch("(title=Инженер)", src, node, useCache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].CN != "Иванов Иван" {
+			t.Errorf("useCache=%v: найдено %d записей: %v", useCache, len(entries), entries)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"gopkg.in/ldap.v2"
+)
+
+// Длина строки LDIF, после которой значение переносится (RFC 2849)
+const ldifLineLength = 76
+
+// Сколько байт двоичного значения показывать в шестнадцатеричном виде
+const binaryPreviewLength = 32
+
+// Атрибуты Active Directory и Exchange, содержащие GUID
+var guidAttributes = map[string]bool{
+	"objectguid":            true,
+	"msexchmailboxguid":     true,
+	"ms-ds-consistencyguid": true,
+}
+
+// Атрибуты, содержащие идентификаторы безопасности Windows (SID)
+var sidAttributes = map[string]bool{
+	"objectsid":          true,
+	"sidhistory":         true,
+	"securityidentifier": true,
+	"tokengroups":        true,
+}
+
+var (
+	// Вкладка "Все атрибуты" панели детальной информации
+	attributesPage   *gtk.Box
+	attributesStore  *gtk.ListStore
+	attributesStatus *gtk.Label
+	// DN записи, выбранной для вкладки, и DN уже показанной записи
+	attributesDN    string
+	attributesShown string
+	attributesEntry *ldap.Entry
+)
+
+// fetchAllAttributes получает запись по DN со всеми пользовательскими (*)
+// и операционными (+) атрибутами
+func fetchAllAttributes(dn string) (*ldap.Entry, error) {
+	var lastErr error
+	for _, s := range sourcesForDN(dn) {
+		entries, err := s.dir.Search(dn, ldap.ScopeBaseObject, "(objectClass=*)", []string{"*", "+"})
+		if err != nil {
+			lastErr = fmt.Errorf("Ошибка получения атрибутов: %v", err)
+			continue
+		}
+		if len(entries) > 0 {
+			return entries[0], nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("%w: %s", errNotFound, dn)
+	}
+	return nil, lastErr
+}
+
+// attributeBytes возвращает значения атрибута в исходном двоичном виде
+func attributeBytes(attr *ldap.EntryAttribute) [][]byte {
+	if len(attr.ByteValues) == len(attr.Values) {
+		return attr.ByteValues
+	}
+	values := make([][]byte, len(attr.Values))
+	for i, value := range attr.Values {
+		values[i] = []byte(value)
+	}
+	return values
+}
+
+// formatAttributeValue преобразует значение атрибута в читаемый вид:
+// GUID и SID показываются в принятой записи, изображения и прочие
+// двоичные данные - кратким описанием
+func formatAttributeValue(name string, value []byte) string {
+	name = strings.ToLower(name)
+	if guidAttributes[name] && len(value) == 16 {
+		return formatGUID(value)
+	}
+	if sidAttributes[name] {
+		if sid, ok := formatSID(value); ok {
+			return sid
+		}
+	}
+	if kind := imageKind(value); kind != "" {
+		return fmt.Sprintf("Изображение %s, %d байт", kind, len(value))
+	}
+	if isText(value) {
+		return string(value)
+	}
+
+	preview := value
+	if len(preview) > binaryPreviewLength {
+		preview = preview[:binaryPreviewLength]
+	}
+	text := fmt.Sprintf("Двоичные данные, %d байт: %s", len(value), hex.EncodeToString(preview))
+	if len(preview) < len(value) {
+		text += "..."
+	}
+	return text
+}
+
+// formatGUID записывает GUID в виде Active Directory: первые три поля
+// хранятся в порядке little-endian
+func formatGUID(b []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b[0:4]),
+		binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]),
+		b[8:10], b[10:16])
+}
+
+// formatSID записывает идентификатор безопасности в виде S-1-5-21-...
+func formatSID(b []byte) (string, bool) {
+	if len(b) < 8 || len(b) != 8+4*int(b[1]) {
+		return "", false
+	}
+	var authority uint64
+	for _, c := range b[2:8] {
+		authority = authority<<8 | uint64(c)
+	}
+	sid := fmt.Sprintf("S-%d-%d", b[0], authority)
+	for i := 8; i < len(b); i += 4 {
+		sid += fmt.Sprintf("-%d", binary.LittleEndian.Uint32(b[i:i+4]))
+	}
+	return sid, true
+}
+
+// imageKind определяет формат изображения по сигнатуре
+func imageKind(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xff, 0xd8, 0xff}):
+		return "JPEG"
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return "PNG"
+	case bytes.HasPrefix(b, []byte("GIF8")):
+		return "GIF"
+	}
+	return ""
+}
+
+// isText проверяет, что значение - текст UTF-8 без управляющих символов
+func isText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// formatLDIF записывает запись каталога в формате LDIF (RFC 2849).
+// Значения, которые нельзя записать как есть, кодируются в base64
+func formatLDIF(entry *ldap.Entry) string {
+	var b strings.Builder
+	writeLDIFValue(&b, "dn", []byte(entry.DN))
+	for _, attr := range entry.Attributes {
+		for _, value := range attributeBytes(attr) {
+			writeLDIFValue(&b, attr.Name, value)
+		}
+	}
+	return b.String()
+}
+
+// writeLDIFValue записывает строку "имя: значение" с переносом длинных строк
+func writeLDIFValue(b *strings.Builder, name string, value []byte) {
+	line := name + ": " + string(value)
+	if !ldifSafe(value) {
+		line = name + ":: " + base64.StdEncoding.EncodeToString(value)
+	}
+	for len(line) > ldifLineLength {
+		b.WriteString(line[:ldifLineLength] + "\n")
+		line = " " + line[ldifLineLength:]
+	}
+	b.WriteString(line + "\n")
+}
+
+// ldifSafe проверяет, что значение можно записать в LDIF без base64
+func ldifSafe(value []byte) bool {
+	if len(value) == 0 {
+		return true
+	}
+	if c := value[0]; c == ' ' || c == ':' || c == '<' || value[len(value)-1] == ' ' {
+		return false
+	}
+	for _, c := range value {
+		if c == 0 || c == '\n' || c == '\r' || c > 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// newAttributesPage создает вкладку "Все атрибуты" панели детальной информации.
+// Атрибуты загружаются, только когда вкладка открыта
+func newAttributesPage() (*gtk.Box, error) {
+	box, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 5)
+	if err != nil {
+		return nil, err
+	}
+
+	attributesStore, err = gtk.ListStoreNew(
+		glib.TYPE_STRING, // Атрибут
+		glib.TYPE_STRING, // Значение
+	)
+	if err != nil {
+		return nil, err
+	}
+	view, err := gtk.TreeViewNewWithModel(attributesStore)
+	if err != nil {
+		return nil, err
+	}
+	addResizableColumn(view, "Атрибут", 0)
+	addResizableColumn(view, "Значение", 1)
+
+	scrolled, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	scrolled.Add(view)
+
+	buttons, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 5)
+	if err != nil {
+		return nil, err
+	}
+	copyButton, err := gtk.ButtonNewWithLabel("Копировать как LDIF")
+	if err != nil {
+		return nil, err
+	}
+	copyButton.Connect("clicked", copyAttributesLDIF)
+	attributesStatus, err = gtk.LabelNew("")
+	if err != nil {
+		return nil, err
+	}
+	buttons.PackStart(copyButton, false, false, 0)
+	buttons.PackStart(attributesStatus, false, false, 0)
+
+	box.PackStart(scrolled, true, true, 0)
+	box.PackStart(buttons, false, false, 0)
+
+	// Сигнал "map" приходит, когда вкладка становится видимой
+	box.Connect("map", loadAttributes)
+	attributesPage = box
+	return box, nil
+}
+
+// setAttributesDN задает запись для вкладки "Все атрибуты".
+// Вызывается только из основного потока GTK
+func setAttributesDN(dn string) {
+	attributesDN = dn
+	if attributesPage != nil && attributesPage.GetMapped() {
+		loadAttributes()
+	}
+}
+
+// loadAttributes загружает и показывает атрибуты выбранной записи.
+// Вызывается только из основного потока GTK
+func loadAttributes() {
+	dn := attributesDN
+	if dn == attributesShown {
+		return
+	}
+	attributesShown = dn
+	attributesEntry = nil
+	attributesStore.Clear()
+	attributesStatus.SetText("")
+	if dn == "" {
+		return
+	}
+
+	attributesStatus.SetText(loadingTitle)
+	go func() {
+		entry, err := fetchAllAttributes(dn)
+		glib.IdleAdd(func() {
+			// Пока шел поиск, могла быть выбрана другая запись
+			if attributesShown != dn {
+				return
+			}
+			if err != nil {
+				attributesShown = ""
+				attributesStatus.SetText(err.Error())
+				return
+			}
+			attributesEntry = entry
+			attributesStatus.SetText(fmt.Sprintf("Атрибутов: %d", len(entry.Attributes)))
+			attributesStore.Set(attributesStore.Append(), []int{0, 1}, []any{"dn", entry.DN})
+			for _, attr := range entry.Attributes {
+				for _, value := range attributeBytes(attr) {
+					attributesStore.Set(attributesStore.Append(), []int{0, 1}, []any{attr.Name, formatAttributeValue(attr.Name, value)})
+				}
+			}
+		})
+	}()
+}
+
+// copyAttributesLDIF копирует показанную запись в буфер обмена в формате LDIF
+func copyAttributesLDIF() {
+	if attributesEntry == nil {
+		return
+	}
+	clipboard, err := gtk.ClipboardGet(gdk.SELECTION_CLIPBOARD)
+	if err != nil {
+		fmt.Printf("Ошибка доступа к буферу обмена: %v\n", err)
+		return
+	}
+	clipboard.SetText(formatLDIF(attributesEntry))
+}
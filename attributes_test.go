@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/ldap.v2"
+)
+
+func TestFormatLDIF(t *testing.T) {
+	long := strings.Repeat("0123456789", 20)
+	entry := &ldap.Entry{
+		DN: "cn=Иванов Иван,ou=ИТ,dc=example",
+		Attributes: []*ldap.EntryAttribute{
+			{Name: "cn", Values: []string{"Иванов Иван"}},
+			{Name: "mail", Values: []string{"ivanov@example.com", "i.ivanov@example.com"}},
+			{Name: "description", Values: []string{long}},
+			{Name: "info", Values: []string{" пробел в начале", ":двоеточие", "<ссылка", "в конце "}},
+			{Name: "postalAddress", Values: []string{"строка 1\nстрока 2"}},
+			{Name: "jpegPhoto", Values: []string{"\xff\xd8\xff\x00\x01"}, ByteValues: [][]byte{{0xff, 0xd8, 0xff, 0x00, 0x01}}},
+			{Name: "empty", Values: []string{""}},
+		},
+	}
+
+	text := formatLDIF(entry)
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if len(line) > ldifLineLength {
+			t.Errorf("строка длиннее %d символов: %q", ldifLineLength, line)
+		}
+	}
+	for _, want := range []string{"mail: ivanov@example.com\n", "mail: i.ivanov@example.com\n", "empty: \n", "\n "} {
+		if !strings.Contains(text, want) {
+			t.Errorf("formatLDIF не содержит %q:\n%s", want, text)
+		}
+	}
+	if !strings.HasPrefix(text, "dn:: ") {
+		t.Errorf("DN с кириллицей не закодирован в base64:\n%s", text)
+	}
+
+	// Разбор результата возвращает исходную запись
+	parsed, err := parseLDIF(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 1 {
+		t.Fatalf("разобрано записей: %d", len(parsed))
+	}
+	if parsed[0].DN != entry.DN {
+		t.Errorf("DN = %q, ожидалось %q", parsed[0].DN, entry.DN)
+	}
+	for _, attr := range entry.Attributes {
+		if got := parsed[0].GetAttributeValues(attr.Name); !reflect.DeepEqual(got, attr.Values) {
+			t.Errorf("%s = %q, ожидалось %q", attr.Name, got, attr.Values)
+		}
+	}
+}
+
+func TestLDIFSafe(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"", true},
+		{"ivanov@example.com", true},
+		{"a: b", true},
+		{" a", false},
+		{"a ", false},
+		{":a", false},
+		{"<a", false},
+		{"a\nb", false},
+		{"a\rb", false},
+		{"a\x00b", false},
+		{"Иванов", false},
+	}
+	for _, tt := range tests {
+		if got := ldifSafe([]byte(tt.value)); got != tt.want {
+			t.Errorf("ldifSafe(%q) = %v, ожидалось %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFormatGUID(t *testing.T) {
+	b := []byte{0x78, 0x56, 0x34, 0x12, 0x34, 0x12, 0x78, 0x56, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}
+	if got, want := formatGUID(b), "12345678-1234-5678-1234-56789abcdef0"; got != want {
+		t.Errorf("formatGUID = %q, ожидалось %q", got, want)
+	}
+	if got := formatAttributeValue("objectGUID", b); got != "12345678-1234-5678-1234-56789abcdef0" {
+		t.Errorf("formatAttributeValue(objectGUID) = %q", got)
+	}
+}
+
+func TestFormatSID(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		want string
+		ok   bool
+	}{
+		// S-1-5-32-544 (BUILTIN\Administrators)
+		{[]byte{1, 2, 0, 0, 0, 0, 0, 5, 32, 0, 0, 0, 0x20, 0x02, 0, 0}, "S-1-5-32-544", true},
+		// S-1-5-21-1004336348-1177238915-682003330-512
+		{[]byte{1, 5, 0, 0, 0, 0, 0, 5,
+			0x15, 0, 0, 0,
+			0xdc, 0xf4, 0xdc, 0x3b,
+			0x83, 0x3d, 0x2b, 0x46,
+			0x82, 0x8b, 0xa6, 0x28,
+			0x00, 0x02, 0, 0}, "S-1-5-21-1004336348-1177238915-682003330-512", true},
+		// S-1-1-0 (Everyone)
+		{[]byte{1, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}, "S-1-1-0", true},
+		{[]byte{1, 2, 0, 0, 0, 0, 0, 5, 32, 0, 0, 0}, "", false},
+		{[]byte{1, 0, 0}, "", false},
+		{nil, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := formatSID(tt.b)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("formatSID(%x) = %q, %v, ожидалось %q, %v", tt.b, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFormatAttributeValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value []byte
+		want  string
+	}{
+		{"cn", []byte("Иванов Иван"), "Иванов Иван"},
+		{"objectSid", []byte{1, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}, "S-1-1-0"},
+		{"objectSid", []byte{1, 9}, "Двоичные данные, 2 байт: 0109"},
+		{"jpegPhoto", []byte{0xff, 0xd8, 0xff, 0xe0}, "Изображение JPEG, 4 байт"},
+		{"photo", []byte("\x89PNG\r\n\x1a\n"), "Изображение PNG, 8 байт"},
+		{"userCertificate", make([]byte, 40), "Двоичные данные, 40 байт: " + strings.Repeat("00", binaryPreviewLength) + "..."},
+	}
+	for _, tt := range tests {
+		if got := formatAttributeValue(tt.name, tt.value); got != tt.want {
+			t.Errorf("formatAttributeValue(%s, %x) = %q, ожидалось %q", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
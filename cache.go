@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Время жизни локального кэша сотрудников по умолчанию, минут
+const defaultCacheTTL = 60
+
+// peopleCacheFile возвращает путь к локальному кэшу сотрудников
+func peopleCacheFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, appName, "people.json"), nil
+}
+
+// cacheTTL возвращает время жизни кэшей из параметра cache_ttl
+func cacheTTL() time.Duration {
+	ttl := config.CacheTTL
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	return time.Duration(ttl) * time.Minute
+}
+
+// cachedPeople возвращает всех сотрудников из локального кэша.
+// Если кэш отсутствует, устарел или refresh=true, данные загружаются из LDAP
+func cachedPeople(refresh bool) ([]LDAPEntry, error) {
+	file, err := peopleCacheFile()
+	if err != nil {
+		return fetchPeople("")
+	}
+
+	if info, err := os.Stat(file); err == nil && !refresh && time.Since(info.ModTime()) < cacheTTL() {
+		if entries, err := readPeopleCache(); err == nil {
+			return entries, nil
+		}
+	}
+
+	entries, err := fetchPeople("")
+	if err != nil {
+		return nil, err
+	}
+
+	// Ошибка записи кэша не мешает работе, данные уже получены
+	if data, err := json.Marshal(entries); err == nil {
+		os.MkdirAll(filepath.Dir(file), 0700)
+		os.WriteFile(file, data, 0600)
+	}
+	return entries, nil
+}
+
+// readPeopleCache читает локальный кэш сотрудников без проверки его возраста
+func readPeopleCache() ([]LDAPEntry, error) {
+	file, err := peopleCacheFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entries []LDAPEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Коды завершения консольных команд
+const (
+	exitOK          = 0
+	exitNotFound    = 1
+	exitServerError = 2
+	exitUsage       = 3
+)
+
+// Форматы вывода консольных команд
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+	formatVCard = "vcard"
+)
+
+// cliCommands консольные команды, выполняемые без инициализации GTK
+var cliCommands = map[string]func(args []string) int{
+	"search":     cliSearch,
+	"show":       cliShow,
+	"tree":       cliTree,
+	"department": cliDepartment,
+	"orgchart":   cliOrgChart,
+	"query":      cliQuery,
+	"dmenu":      cliDmenu,
+	"pick":       cliPick,
+}
+
+// runCLI выполняет консольную команду и возвращает код завершения
+func runCLI(name string, args []string) int {
+	return cliCommands[name](args)
+}
+
+// newCLIFlags создает набор флагов команды с общим флагом --format
+func newCLIFlags(name, usage string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	format := flags.String("format", formatTable, "формат вывода: table, json, csv, vcard")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Использование: %s %s %s\n", appName, name, usage)
+		flags.PrintDefaults()
+	}
+	return flags, format
+}
+
+// parseCLIArgs разбирает флаги команды и проверяет количество аргументов
+func parseCLIArgs(flags *flag.FlagSet, format *string, args []string, nargs int) bool {
+	if err := flags.Parse(args); err != nil {
+		return false
+	}
+	switch *format {
+	case formatTable, formatJSON, formatCSV, formatVCard:
+	default:
+		fmt.Fprintf(os.Stderr, "Неизвестный формат вывода: %s\n", *format)
+		return false
+	}
+	if nargs >= 0 && flags.NArg() != nargs {
+		flags.Usage()
+		return false
+	}
+	return true
+}
+
+// peopleExitCode выводит список людей и возвращает код завершения
+func peopleExitCode(entries []LDAPEntry, err error, format string) int {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitServerError
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(os.Stderr, "Ничего не найдено")
+		return exitNotFound
+	}
+	if err := writePeople(os.Stdout, entries, format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitServerError
+	}
+	return exitOK
+}
+
+func cliSearch(args []string) int {
+	flags, format := newCLIFlags("search", "[--format table|json|csv|vcard] <текст>")
+	if !parseCLIArgs(flags, format, args, -1) {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	entries, err := searchByText(strings.Join(flags.Args(), " "))
+	return peopleExitCode(entries, err, *format)
+}
+
+// cliQuery выводит результаты в формате query_command почтовых клиентов
+// mutt/neomutt/aerc: строка состояния, затем "email<TAB>ФИО<TAB>отдел"
+func cliQuery(args []string) int {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Использование: %s query <текст>\n", appName)
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	entries, err := searchByText(strings.Join(flags.Args(), " "))
+	if err != nil {
+		fmt.Println(err)
+		return exitServerError
+	}
+
+	var lines []string
+	for _, e := range entries {
+		if e.Mail == "" {
+			continue
+		}
+		dept := e.OU
+		if dept == "" {
+			dept = e.O
+		}
+		lines = append(lines, e.Mail+"\t"+e.CN+"\t"+dept)
+	}
+
+	if len(lines) == 0 {
+		fmt.Println("Ничего не найдено")
+		return exitNotFound
+	}
+
+	fmt.Printf("%s: найдено %d\n", appName, len(lines))
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	return exitOK
+}
+
+func cliShow(args []string) int {
+	flags, format := newCLIFlags("show", "[--format table|json|csv|vcard] <dn>")
+	if !parseCLIArgs(flags, format, args, 1) {
+		return exitUsage
+	}
+
+	entry, err := fetchPerson(flags.Arg(0))
+	if errors.Is(err, errNotFound) {
+		fmt.Fprintln(os.Stderr, err)
+		return exitNotFound
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitServerError
+	}
+
+	if *format == formatTable {
+		fmt.Println(formatDetails(entry))
+		return exitOK
+	}
+	return peopleExitCode([]LDAPEntry{entry}, nil, *format)
+}
+
+func cliDepartment(args []string) int {
+	flags, format := newCLIFlags("department", "[--format table|json|csv|vcard] [--group org|location|title|address] <[Источник:]Организация:Отдел[:...]>")
+	group := addGroupFlag(flags)
+	if !parseCLIArgs(flags, format, args, 1) || !setGrouping(*group) {
+		return exitUsage
+	}
+
+	src, node, code := cliFindDepartment(flags.Arg(0))
+	if node == nil {
+		return code
+	}
+
+	entries, err := fetchNodePeople(src, node)
+	return peopleExitCode(entries, err, *format)
+}
+
+// cliOrgChart выводит схему подчиненности отдела в формате Graphviz DOT или SVG
+func cliOrgChart(args []string) int {
+	flags := flag.NewFlagSet("orgchart", flag.ContinueOnError)
+	format := flags.String("format", "dot", "формат вывода: dot, svg")
+	group := addGroupFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Использование: %s orgchart [--format dot|svg] [--group org|location|title|address] <[Источник:]Организация:Отдел[:...]>\n", appName)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || !setGrouping(*group) {
+		return exitUsage
+	}
+	if *format != "dot" && *format != "svg" {
+		fmt.Fprintf(os.Stderr, "Неизвестный формат вывода: %s\n", *format)
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	src, node, code := cliFindDepartment(flags.Arg(0))
+	if node == nil {
+		return code
+	}
+	entries, err := fetchNodePeople(src, node)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitServerError
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(os.Stderr, "Ничего не найдено")
+		return exitNotFound
+	}
+
+	data, err := orgChartData(node.Name, entries, *format == "svg")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitServerError
+	}
+	os.Stdout.Write(data)
+	return exitOK
+}
+
+// cliFindDepartment загружает дерево и ищет отдел по пути из командной строки.
+// Если отдел не найден, возвращает код завершения
+func cliFindDepartment(pathStr string) (*source, *OrgNode, int) {
+	// Консольные команды всегда загружают дерево целиком
+	roots, err := fetchOrgTrees(false, currentGrouping)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, exitServerError
+	}
+
+	parts := strings.Split(pathStr, ":")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	src, root, parts := orgTreeRoot(roots, parts)
+	var node *OrgNode
+	if root != nil {
+		node = findOrgNode(root, parts)
+	}
+	if node == nil {
+		fmt.Fprintf(os.Stderr, "Отдел не найден: %s\n", pathStr)
+		return nil, nil, exitNotFound
+	}
+
+	if !node.searchable() {
+		fmt.Fprintln(os.Stderr, "Укажите отдел, а не организацию")
+		return nil, nil, exitUsage
+	}
+	return src, node, exitOK
+}
+
+func cliTree(args []string) int {
+	flags, format := newCLIFlags("tree", "[--format table|json|csv] [--group org|location|title|address]")
+	group := addGroupFlag(flags)
+	if !parseCLIArgs(flags, format, args, 0) || !setGrouping(*group) {
+		return exitUsage
+	}
+	if *format == formatVCard {
+		fmt.Fprintln(os.Stderr, "Формат vcard не поддерживается для дерева")
+		return exitUsage
+	}
+
+	roots, err := fetchOrgTrees(false, currentGrouping)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitServerError
+	}
+
+	// Если источник один, его корень не выводится
+	var nodes []*OrgNode
+	if len(sources) == 1 {
+		nodes = sortedChildren(roots[0])
+	} else {
+		nodes = roots
+	}
+	if len(nodes) == 0 {
+		fmt.Fprintln(os.Stderr, "Ничего не найдено")
+		return exitNotFound
+	}
+
+	walk := func(visit func(path []string)) {
+		for _, node := range nodes {
+			path := []string{node.Name}
+			visit(path)
+			walkOrgTree(node, path, visit)
+		}
+	}
+
+	switch *format {
+	case formatJSON:
+		var out []orgNodeOutput
+		for _, node := range nodes {
+			out = append(out, orgNodeJSON(node))
+		}
+		err = writeJSON(os.Stdout, out)
+	case formatCSV:
+		w := csv.NewWriter(os.Stdout)
+		walk(func(path []string) {
+			w.Write([]string{strings.Join(path, ":"), fmt.Sprint(len(path))})
+		})
+		w.Flush()
+		err = w.Error()
+	default:
+		walk(func(path []string) {
+			fmt.Printf("%s%s\n", strings.Repeat("  ", len(path)-1), path[len(path)-1])
+		})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitServerError
+	}
+	return exitOK
+}
+
+// addGroupFlag добавляет флаг --group для выбора группировки дерева
+func addGroupFlag(flags *flag.FlagSet) *string {
+	return flags.String("group", groupingOrg, "группировка дерева: org, location, title, address")
+}
+
+// setGrouping устанавливает группировку дерева по имени
+func setGrouping(id string) bool {
+	for _, g := range groupings {
+		if g.ID == id {
+			currentGrouping = id
+			shownGrouping.Store(id)
+			return true
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Неизвестная группировка: %s\n", id)
+	return false
+}
+
+// orgTreeRoot выбирает дерево источника для пути к отделу. Если источников
+// несколько, путь начинается с имени источника, и оно отбрасывается
+func orgTreeRoot(roots []*OrgNode, parts []string) (*source, *OrgNode, []string) {
+	if len(sources) == 1 {
+		return sources[0], roots[0], parts
+	}
+	for _, root := range roots {
+		if root.Name == parts[0] {
+			return sourceOfRoot(root), root, parts[1:]
+		}
+	}
+	return nil, nil, nil
+}
+
+// findOrgNode ищет узел дерева по пути от организации
+func findOrgNode(root *OrgNode, parts []string) *OrgNode {
+	node := root
+	for _, part := range parts {
+		child, ok := node.Children[part]
+		if !ok {
+			return nil
+		}
+		node = child
+	}
+	return node
+}
+
+// sortedChildren возвращает дочерние узлы в порядке сортировки дерева
+func sortedChildren(node *OrgNode) []*OrgNode {
+	var children []*OrgNode
+	for _, child := range node.Children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return strings.ToLower(children[i].Name) < strings.ToLower(children[j].Name)
+	})
+	return children
+}
+
+// walkOrgTree обходит дерево, передавая путь к каждому узлу кроме корня
+func walkOrgTree(node *OrgNode, path []string, visit func(path []string)) {
+	for _, child := range sortedChildren(node) {
+		childPath := append(append([]string{}, path...), child.Name)
+		visit(childPath)
+		walkOrgTree(child, childPath, visit)
+	}
+}
+
+// orgNodeOutput узел дерева для вывода в JSON
+type orgNodeOutput struct {
+	Name     string          `json:"name"`
+	Children []orgNodeOutput `json:"children,omitempty"`
+}
+
+func orgNodeJSON(node *OrgNode) orgNodeOutput {
+	out := orgNodeOutput{Name: node.Name}
+	for _, child := range sortedChildren(node) {
+		out.Children = append(out.Children, orgNodeJSON(child))
+	}
+	return out
+}
+
+func writeJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// writePeople выводит список людей в заданном формате
+func writePeople(w io.Writer, entries []LDAPEntry, format string) error {
+	switch format {
+	case formatJSON:
+		return writeJSON(w, entries)
+
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"dn", "cn", "telephoneNumber", "mail", "title", "ou", "o", "l", "postalAddress", "source"})
+		for _, e := range entries {
+			cw.Write([]string{e.DN, e.CN, e.TelephoneNumber, e.Mail, e.Title, e.OU, e.O, e.L, e.PostalAddress, e.Source})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case formatVCard:
+		for _, e := range entries {
+			if _, err := io.WriteString(w, formatVCardEntry(e)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if len(sources) > 1 {
+		fmt.Fprintln(tw, "ФИО\tТелефон\tEmail\tДолжность\tОтдел\tОрганизация\tИсточник")
+		for _, e := range entries {
+			v, _, _ := resultValues(e)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", v[0], v[1], v[2], v[3], v[4], v[5], e.Source)
+		}
+		return tw.Flush()
+	}
+	fmt.Fprintln(tw, "ФИО\tТелефон\tEmail\tДолжность\tОтдел\tОрганизация")
+	for _, e := range entries {
+		v, _, _ := resultValues(e)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", v[0], v[1], v[2], v[3], v[4], v[5])
+	}
+	return tw.Flush()
+}
+
+// vcardEscape экранирует значение свойства vCard
+var vcardEscape = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`)
+
+// formatVCardEntry формирует карточку vCard 3.0
+func formatVCardEntry(e LDAPEntry) string {
+	var b strings.Builder
+	b.WriteString("BEGIN:VCARD\r\nVERSION:3.0\r\n")
+	b.WriteString("FN:" + vcardEscape.Replace(e.CN) + "\r\n")
+	b.WriteString("N:" + vcardEscape.Replace(e.SN) + ";" + vcardEscape.Replace(e.GivenName) + ";;;\r\n")
+	if e.O != "" || e.OU != "" {
+		b.WriteString("ORG:" + vcardEscape.Replace(e.O) + ";" + vcardEscape.Replace(e.OU) + "\r\n")
+	}
+	if e.Title != "" {
+		b.WriteString("TITLE:" + vcardEscape.Replace(e.Title) + "\r\n")
+	}
+	if e.TelephoneNumber != "" {
+		b.WriteString("TEL;TYPE=WORK,VOICE:" + vcardEscape.Replace(e.TelephoneNumber) + "\r\n")
+	}
+	if e.Mail != "" {
+		b.WriteString("EMAIL;TYPE=INTERNET,WORK:" + vcardEscape.Replace(e.Mail) + "\r\n")
+	}
+	if e.PostalAddress != "" || e.L != "" {
+		b.WriteString("ADR;TYPE=WORK:;;" + vcardEscape.Replace(e.PostalAddress) + ";" + vcardEscape.Replace(e.L) + ";;;\r\n")
+	}
+	b.WriteString("END:VCARD\r\n")
+	return b.String()
+}
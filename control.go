@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// Версия протокола управления запущенным экземпляром.
+//
+// Каждая команда передается одной строкой JSON, например
+//
+//	{"version":1,"id":1,"command":"search","query":"Иванов"}
+//
+// и на каждую команду возвращается одна строка ответа
+//
+//	{"version":1,"id":1,"ok":true,"result":[...]}
+//
+// В одном соединении можно передать несколько команд подряд.
+// Для совместимости также принимаются текстовые строки
+// "activate" и "incoming <номер>" - на них ответ не отправляется.
+const controlProtocolVersion = 1
+
+// Максимальный размер строки команды
+const controlMaxLine = 1024 * 1024
+
+// controlRequest команда управления
+type controlRequest struct {
+	Version int    `json:"version"`
+	ID      any    `json:"id,omitempty"`
+	Command string `json:"command"`
+	Query   string `json:"query,omitempty"`
+	DN      string `json:"dn,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Number  string `json:"number,omitempty"`
+	Text    string `json:"text,omitempty"`
+}
+
+// controlResponse ответ на команду управления
+type controlResponse struct {
+	Version int    `json:"version"`
+	ID      any    `json:"id,omitempty"`
+	OK      bool   `json:"ok"`
+	Result  any    `json:"result,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// instanceStatus ответ на команду status
+type instanceStatus struct {
+	Version    string `json:"version"`
+	Protocol   int    `json:"protocol"`
+	PID        int    `json:"pid"`
+	Visible    bool   `json:"visible"`
+	LDAPServer string `json:"ldap_server"`
+	ConfigFile string `json:"config_file"`
+	Results    int    `json:"results"`
+}
+
+func handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	// Команды принимаются только от процессов того же пользователя
+	if err := checkPeerCredentials(conn); err != nil {
+		fmt.Printf("Отклонено подключение к сокету: %v\n", err)
+		return
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), controlMaxLine)
+	encoder := json.NewEncoder(conn)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		// Текстовые команды прежних версий
+		if !strings.HasPrefix(line, "{") {
+			handleLegacyCommand(line)
+			continue
+		}
+
+		var req controlRequest
+		var resp controlResponse
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			resp.Error = "некорректный JSON: " + err.Error()
+		} else {
+			resp = executeControlRequest(req)
+		}
+		resp.Version = controlProtocolVersion
+
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// handleLegacyCommand обрабатывает текстовые команды "activate" и "incoming <номер>".
+// Вызывается не из основного потока GTK
+func handleLegacyCommand(line string) {
+	if line == "activate" {
+		runOnMain(restoreFromTray)
+	} else if number, ok := strings.CutPrefix(line, "incoming "); ok {
+		handleIncomingCall(strings.TrimSpace(number))
+	}
+}
+
+// executeControlRequest выполняет команду и формирует ответ
+func executeControlRequest(req controlRequest) controlResponse {
+	resp := controlResponse{ID: req.ID}
+
+	if req.Version > controlProtocolVersion {
+		resp.Error = fmt.Sprintf("неподдерживаемая версия протокола: %d", req.Version)
+		return resp
+	}
+
+	if config.Debug {
+		fmt.Printf("Команда управления: %s\n", req.Command)
+	}
+
+	result, err := runControlCommand(req)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+
+	resp.OK = true
+	resp.Result = result
+	return resp
+}
+
+func runControlCommand(req controlRequest) (any, error) {
+	switch req.Command {
+	case "activate":
+		runOnMain(restoreFromTray)
+		return nil, nil
+
+	case "search":
+		if req.Query == "" {
+			return nil, fmt.Errorf("не задан параметр query")
+		}
+		entries, err := searchByText(req.Query)
+		if err != nil {
+			return nil, err
+		}
+		runOnMain(func() {
+			searchEntry.SetText(req.Query)
+			showPeople(entries)
+		})
+		return entries, nil
+
+	case "show":
+		if req.DN == "" {
+			return nil, fmt.Errorf("не задан параметр dn")
+		}
+		entry, err := fetchPerson(req.DN)
+		if err != nil {
+			return nil, err
+		}
+		runOnMain(func() {
+			showPeople([]LDAPEntry{entry})
+			setDetailsText(formatDetails(entry))
+		})
+		return entry, nil
+
+	case "select-department":
+		if req.Path == "" {
+			return nil, fmt.Errorf("не задан параметр path")
+		}
+		var src *source
+		var node *OrgNode
+		var found bool
+		if config.LazyTree {
+			// Загружаем узлы по пути, если они еще не загружены
+			revealByPath(req.Path)
+		}
+		runOnMain(func() {
+			found = selectByPath(req.Path)
+			if found {
+				src, node = selectedTreeNode()
+			}
+		})
+		if !found || node == nil {
+			return nil, fmt.Errorf("отдел не найден: %s", req.Path)
+		}
+		if !node.searchable() {
+			return []LDAPEntry{}, nil
+		}
+		entries, err := fetchNodePeople(src, node)
+		if err != nil {
+			return nil, err
+		}
+		runOnMain(func() {
+			showPeople(entries)
+		})
+		return entries, nil
+
+	case "reload":
+		var grouping string
+		runOnMain(func() {
+			grouping = currentGrouping
+		})
+		clearTreeValues()
+		go loadLDAPData(grouping)
+		return nil, nil
+
+	case "incoming":
+		if req.Number == "" {
+			return nil, fmt.Errorf("не задан параметр number")
+		}
+		handleIncomingCall(req.Number)
+		return nil, nil
+
+	case "copy":
+		if req.Text == "" {
+			return nil, fmt.Errorf("не задан параметр text")
+		}
+		var err error
+		runOnMain(func() {
+			var clipboard *gtk.Clipboard
+			clipboard, err = gtk.ClipboardGet(gdk.SELECTION_CLIPBOARD)
+			if err == nil {
+				clipboard.SetText(req.Text)
+			}
+		})
+		return nil, err
+
+	case "status":
+		status := instanceStatus{
+			Version:    appVersion,
+			Protocol:   controlProtocolVersion,
+			PID:        os.Getpid(),
+			LDAPServer: config.LDAPServer,
+			ConfigFile: configPath,
+		}
+		runOnMain(func() {
+			status.Visible = mainWindow.GetVisible()
+			status.Results = len(searchResult)
+		})
+		return status, nil
+
+	case "quit":
+		// Даем время отправить ответ перед завершением
+		glib.TimeoutAdd(100, func() bool {
+			gtk.MainQuit()
+			return false
+		})
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("неизвестная команда: %s", req.Command)
+}
+
+// runOnMain выполняет функцию в основном потоке GTK и дожидается ее завершения.
+// Нельзя вызывать из основного потока GTK
+func runOnMain(f func()) {
+	done := make(chan struct{})
+	glib.IdleAdd(func() {
+		f()
+		close(done)
+	})
+	<-done
+}
+
+// sendInstanceCommand передает команду запущенному экземпляру через Unix socket
+// и возвращает его ответ
+func sendInstanceCommand(req controlRequest) (*controlResponse, error) {
+	conn, err := dialInstance()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req.Version = controlProtocolVersion
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), controlMaxLine)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("нет ответа от запущенного экземпляра")
+	}
+
+	var resp controlResponse
+	if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return &resp, fmt.Errorf("%s", resp.Error)
+	}
+	return &resp, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+const (
+	dbusName         = "io.github.imax1000.LdapPhonebook"
+	dbusPath         = dbus.ObjectPath("/io/github/imax1000/LdapPhonebook")
+	dbusInterface    = "io.github.imax1000.LdapPhonebook"
+	dbusAppInterface = "org.freedesktop.Application"
+)
+
+// Описание интерфейсов для org.freedesktop.DBus.Introspectable
+const dbusIntrospectXML = `
+<node>
+	<interface name="` + dbusInterface + `">
+		<method name="Activate"/>
+		<method name="Search">
+			<arg name="query" type="s" direction="in"/>
+			<arg name="dns" type="as" direction="out"/>
+		</method>
+		<method name="ShowPerson">
+			<arg name="dn" type="s" direction="in"/>
+		</method>
+		<signal name="ContactsChanged"/>
+	</interface>
+	<interface name="` + dbusAppInterface + `">
+		<method name="Activate">
+			<arg name="platform_data" type="a{sv}" direction="in"/>
+		</method>
+		<method name="Open">
+			<arg name="uris" type="as" direction="in"/>
+			<arg name="platform_data" type="a{sv}" direction="in"/>
+		</method>
+		<method name="ActivateAction">
+			<arg name="action_name" type="s" direction="in"/>
+			<arg name="parameter" type="av" direction="in"/>
+			<arg name="platform_data" type="a{sv}" direction="in"/>
+		</method>
+	</interface>` + introspect.IntrospectDataString + `</node>`
+
+// Подключение к сессионной шине, nil если шина недоступна
+var sessionBus *dbus.Conn
+
+// phonebookService реализует интерфейс io.github.imax1000.LdapPhonebook
+type phonebookService struct{}
+
+// applicationService реализует org.freedesktop.Application для активации
+// из окружения рабочего стола в стиле GApplication
+type applicationService struct{}
+
+// claimBusName подключается к сессионной шине и пытается занять имя программы.
+// Возвращает true, если имя уже занято другим экземпляром
+func claimBusName() (bool, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return false, err
+	}
+
+	reply, err := conn.RequestName(dbusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Close()
+		return false, err
+	}
+
+	sessionBus = conn
+	return reply != dbus.RequestNameReplyPrimaryOwner, nil
+}
+
+// releaseBusName освобождает имя программы на сессионной шине
+func releaseBusName() {
+	if sessionBus != nil {
+		sessionBus.ReleaseName(dbusName)
+		sessionBus.Close()
+		sessionBus = nil
+	}
+}
+
+// activateViaBus активирует запущенный экземпляр через D-Bus
+func activateViaBus() error {
+	obj := sessionBus.Object(dbusName, dbusPath)
+	return obj.Call(dbusInterface+".Activate", 0).Err
+}
+
+// exportDBusObjects публикует интерфейсы программы на сессионной шине
+func exportDBusObjects() {
+	if sessionBus == nil {
+		return
+	}
+
+	if err := sessionBus.Export(phonebookService{}, dbusPath, dbusInterface); err != nil {
+		fmt.Printf("Ошибка публикации интерфейса D-Bus: %v\n", err)
+		return
+	}
+	if err := sessionBus.Export(applicationService{}, dbusPath, dbusAppInterface); err != nil {
+		fmt.Printf("Ошибка публикации интерфейса D-Bus: %v\n", err)
+		return
+	}
+	if err := sessionBus.Export(introspect.Introspectable(dbusIntrospectXML), dbusPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		fmt.Printf("Ошибка публикации интерфейса D-Bus: %v\n", err)
+	}
+
+	exportSearchProvider()
+}
+
+// emitContactsChanged отправляет сигнал об обновлении данных справочника
+func emitContactsChanged() {
+	if sessionBus == nil {
+		return
+	}
+	if err := sessionBus.Emit(dbusPath, dbusInterface+".ContactsChanged"); err != nil && config.Debug {
+		fmt.Printf("Ошибка отправки сигнала D-Bus: %v\n", err)
+	}
+}
+
+func (phonebookService) Activate() *dbus.Error {
+	glib.IdleAdd(restoreFromTray)
+	return nil
+}
+
+func (phonebookService) Search(query string) ([]string, *dbus.Error) {
+	result, err := runControlCommand(controlRequest{Command: "search", Query: query})
+	if err != nil {
+		return nil, dbus.MakeFailedError(err)
+	}
+	glib.IdleAdd(restoreFromTray)
+
+	dns := []string{}
+	for _, entry := range result.([]LDAPEntry) {
+		dns = append(dns, entry.DN)
+	}
+	return dns, nil
+}
+
+func (phonebookService) ShowPerson(dn string) *dbus.Error {
+	if _, err := runControlCommand(controlRequest{Command: "show", DN: dn}); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	glib.IdleAdd(restoreFromTray)
+	return nil
+}
+
+func (applicationService) Activate(platformData map[string]dbus.Variant) *dbus.Error {
+	glib.IdleAdd(restoreFromTray)
+	return nil
+}
+
+func (applicationService) Open(uris []string, platformData map[string]dbus.Variant) *dbus.Error {
+	glib.IdleAdd(restoreFromTray)
+	return nil
+}
+
+func (applicationService) ActivateAction(name string, parameter []dbus.Variant, platformData map[string]dbus.Variant) *dbus.Error {
+	switch name {
+	case "activate":
+		glib.IdleAdd(restoreFromTray)
+	case "search":
+		if len(parameter) == 0 {
+			return dbus.MakeFailedError(fmt.Errorf("не задана строка поиска"))
+		}
+		query, ok := parameter[0].Value().(string)
+		if !ok {
+			return dbus.MakeFailedError(fmt.Errorf("строка поиска должна иметь тип s"))
+		}
+		_, err := phonebookService{}.Search(query)
+		return err
+	case "quit":
+		glib.IdleAdd(gtk.MainQuit)
+	default:
+		return dbus.MakeFailedError(fmt.Errorf("неизвестное действие: %s", name))
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+// Настройки отдельной сессионной шины для тестов
+const testBusConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+	<type>session</type>
+	<listen>unix:dir=%s</listen>
+	<policy context="default">
+		<allow send_destination="*" eavesdrop="true"/>
+		<allow eavesdrop="true"/>
+		<allow own="*"/>
+	</policy>
+</busconfig>
+`
+
+// startTestBus запускает отдельный dbus-daemon и направляет на него
+// подключения к сессионной шине. Если dbus-daemon нет, тест пропускается
+func startTestBus(t *testing.T) {
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon не найден")
+	}
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "bus.conf")
+	if err := os.WriteFile(configFile, []byte(fmt.Sprintf(testBusConfig, dir)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(path, "--config-file="+configFile, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("не удалось запустить dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Skipf("dbus-daemon не сообщил адрес: %v", err)
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", strings.TrimSpace(address))
+}
+
+func TestDBusInterface(t *testing.T) {
+	startTestBus(t)
+
+	// Справочник из файла и кэш фотографий во временном каталоге
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	file := filepath.Join(dir, "people.ldif")
+	data := `dn: cn=Иванов Иван,dc=example
+objectClass: inetOrgPerson
+cn: Иванов Иван
+title: Инженер
+ou: ИТ
+`
+	if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	directory, err := newDirectory(SourceConfig{Backend: backendLDIF, DataFile: file, BaseDN: "dc=example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := sources
+	sources = []*source{{BaseDN: "dc=example", dir: directory}}
+	defer func() { sources = saved }()
+
+	// Фотография уже в кэше, поэтому поисковый провайдер не загружает ее в фоне
+	dn := "cn=Иванов Иван,dc=example"
+	avatar := filepath.Join(dir, appName, "avatars", fmt.Sprintf("%x.jpg", sha1.Sum([]byte(dn))))
+	if err := os.MkdirAll(filepath.Dir(avatar), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(avatar, []byte{0xff, 0xd8, 0xff}, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	running, err := claimBusName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseBusName()
+	if running {
+		t.Fatal("имя на отдельной шине уже занято")
+	}
+	exportDBusObjects()
+
+	client, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	t.Run("SingleInstance", func(t *testing.T) {
+		reply, err := client.RequestName(dbusName, dbus.NameFlagDoNotQueue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply != dbus.RequestNameReplyExists {
+			t.Errorf("второй экземпляр получил имя: %v", reply)
+		}
+	})
+
+	t.Run("Introspect", func(t *testing.T) {
+		for path, want := range map[dbus.ObjectPath][]string{
+			dbusPath:           {dbusInterface, dbusAppInterface},
+			searchProviderPath: {searchProviderInterface},
+		} {
+			node, err := introspect.Call(client.Object(dbusName, path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := map[string]bool{}
+			for _, iface := range node.Interfaces {
+				names[iface.Name] = true
+			}
+			for _, name := range want {
+				if !names[name] {
+					t.Errorf("%s: нет интерфейса %s", path, name)
+				}
+			}
+		}
+	})
+
+	obj := client.Object(dbusName, dbusPath)
+	t.Run("Errors", func(t *testing.T) {
+		calls := []struct {
+			method string
+			args   []any
+		}{
+			{dbusInterface + ".Search", []any{""}},
+			{dbusInterface + ".ShowPerson", []any{""}},
+			{dbusAppInterface + ".ActivateAction", []any{"search", []dbus.Variant{}, map[string]dbus.Variant{}}},
+			{dbusAppInterface + ".ActivateAction", []any{"search", []dbus.Variant{dbus.MakeVariant(42)}, map[string]dbus.Variant{}}},
+			{dbusAppInterface + ".ActivateAction", []any{"unknown", []dbus.Variant{}, map[string]dbus.Variant{}}},
+		}
+		for _, c := range calls {
+			if err := obj.Call(c.method, 0, c.args...).Err; err == nil {
+				t.Errorf("%s%v: ожидалась ошибка", c.method, c.args)
+			}
+		}
+	})
+
+	t.Run("SearchProvider", func(t *testing.T) {
+		provider := client.Object(dbusName, searchProviderPath)
+		var ids []string
+		if err := provider.Call(searchProviderInterface+".GetInitialResultSet", 0, []string{}).Store(&ids); err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 0 {
+			t.Errorf("пустой запрос вернул %q", ids)
+		}
+
+		if err := provider.Call(searchProviderInterface+".GetInitialResultSet", 0, []string{"иванов"}).Store(&ids); err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 1 || ids[0] != dn {
+			t.Fatalf("GetInitialResultSet = %q", ids)
+		}
+
+		var metas []map[string]dbus.Variant
+		if err := provider.Call(searchProviderInterface+".GetResultMetas", 0, append(ids, "cn=unknown")).Store(&metas); err != nil {
+			t.Fatal(err)
+		}
+		if len(metas) != 1 {
+			t.Fatalf("GetResultMetas вернул %d записей", len(metas))
+		}
+		for name, want := range map[string]string{"id": dn, "name": "Иванов Иван", "description": "Инженер, ИТ", "gicon": avatar} {
+			if got, _ := metas[0][name].Value().(string); got != want {
+				t.Errorf("%s = %q, ожидалось %q", name, got, want)
+			}
+		}
+	})
+
+	t.Run("ContactsChanged", func(t *testing.T) {
+		if err := client.AddMatchSignal(dbus.WithMatchInterface(dbusInterface), dbus.WithMatchMember("ContactsChanged")); err != nil {
+			t.Fatal(err)
+		}
+		signals := make(chan *dbus.Signal, 1)
+		client.Signal(signals)
+		defer client.RemoveSignal(signals)
+
+		emitContactsChanged()
+		select {
+		case signal := <-signals:
+			if signal.Name != dbusInterface+".ContactsChanged" || signal.Path != dbusPath {
+				t.Errorf("получен сигнал %s %s", signal.Path, signal.Name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("сигнал ContactsChanged не получен")
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+
+	"gopkg.in/ldap.v2"
+)
+
+// Источники данных справочника
+const (
+	backendLDAP = "ldap"
+	backendLDIF = "ldif"
+	backendCSV  = "csv"
+	backendJSON = "json"
+)
+
+// Directory источник данных справочника: LDAP сервер или локальный файл.
+// Поиск выполняется с семантикой LDAP: база, область и фильтр RFC 4515
+type Directory interface {
+	Search(baseDN string, scope int, filter string, attributes []string) ([]*ldap.Entry, error)
+	// Values возвращает различные значения атрибута у записей поддерева,
+	// подходящих под фильтр. Пустая строка - есть записи без атрибута
+	Values(baseDN string, filter string, attribute string) ([]string, error)
+}
+
+// newDirectory создает источник данных по настройкам конфига
+func newDirectory(cfg SourceConfig) (Directory, error) {
+	switch cfg.Backend {
+	case "", backendLDAP:
+		return &ldapDirectory{server: cfg.LDAPServer, bindDN: cfg.BindDN, password: cfg.BindPassword}, nil
+	case backendLDIF, backendCSV, backendJSON:
+		if cfg.DataFile == "" {
+			return nil, fmt.Errorf("Для источника %s не задан параметр data_file", cfg.Backend)
+		}
+		return &fileDirectory{format: cfg.Backend, path: cfg.DataFile, baseDN: cfg.BaseDN}, nil
+	}
+	return nil, fmt.Errorf("Неизвестный источник данных: %s", cfg.Backend)
+}
+
+// ldapDirectory выполняет поиск на LDAP сервере
+type ldapDirectory struct {
+	server   string
+	bindDN   string
+	password string
+}
+
+// connect подключается к LDAP серверу и выполняет аутентификацию
+func (d *ldapDirectory) connect() (*ldap.Conn, error) {
+	// Подключаемся к LDAP серверу
+	l, err := ldap.Dial("tcp", d.server)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка подключения к LDAP серверу: %v", err)
+	}
+
+	// Аутентификация
+	err = l.Bind(d.bindDN, d.password)
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("Ошибка аутентификации в LDAP: %v", err)
+	}
+	return l, nil
+}
+
+func (d *ldapDirectory) Search(baseDN string, scope int, filter string, attributes []string) ([]*ldap.Entry, error) {
+	l, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+
+	searchRequest := ldap.NewSearchRequest(
+		baseDN,
+		scope, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		attributes,
+		nil,
+	)
+
+	sr, err := l.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+	return sr.Entries, nil
+}
+
+// Values перебирает значения запросами с ограничением в одну запись:
+// найденные значения исключаются из фильтра следующего запроса.
+// Так с сервера передается по записи на значение, а не все записи
+// под фильтром. Запросы выполняются в одном подключении
+func (d *ldapDirectory) Values(baseDN string, filter string, attribute string) ([]string, error) {
+	l, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+
+	var values []string
+	var exclude string
+	for {
+		searchRequest := ldap.NewSearchRequest(
+			baseDN,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, 0, false,
+			"(&"+filter+exclude+")",
+			[]string{attribute},
+			nil,
+		)
+
+		sr, err := l.Search(searchRequest)
+		if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, err
+		}
+		if sr == nil || len(sr.Entries) == 0 {
+			return values, nil
+		}
+
+		found := attributeValues(sr.Entries[0], attribute)
+		if len(found) == 0 {
+			// Записи без атрибута исключаются все сразу
+			values = append(values, "")
+			exclude += "(" + attribute + "=*)"
+			continue
+		}
+		for _, value := range found {
+			values = append(values, value)
+			exclude += "(!(" + attribute + "=" + escapeFilter(value) + "))"
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/asn1-ber.v1"
+	"gopkg.in/ldap.v2"
+)
+
+// EntryTypeConfig настройки типа записей кроме сотрудников:
+// переговорных, общих ящиков, факсов и т.п.
+type EntryTypeConfig struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	// Фильтр записей этого типа, например "(objectClass=room)"
+	Filter string `json:"filter"`
+	// Имя значка из темы, например "x-office-calendar"
+	Icon string `json:"icon,omitempty"`
+	// Атрибуты для колонок таблицы результатов по порядку:
+	// ФИО, Телефон, Email, Должность, Отдел, Организация
+	Columns []string `json:"columns,omitempty"`
+	// Шаблон карточки, атрибуты подставляются вместо {имя}
+	Card string `json:"card,omitempty"`
+}
+
+// entryType тип записей со скомпилированным фильтром
+type entryType struct {
+	EntryTypeConfig
+	packet *ber.Packet
+}
+
+// Дополнительные типы записей из конфига
+var entryTypes []*entryType
+
+// Подстановка атрибута в шаблоне карточки
+var cardPlaceholder = regexp.MustCompile(`\{([A-Za-z0-9;-]+)\}`)
+
+// newEntryTypes проверяет настройки типов записей
+func newEntryTypes(configs []EntryTypeConfig) ([]*entryType, error) {
+	var result []*entryType
+	names := map[string]bool{}
+	for _, tc := range configs {
+		if tc.Name == "" || tc.Filter == "" {
+			return nil, fmt.Errorf("Для типа записей не задано имя или фильтр")
+		}
+		if names[tc.Name] {
+			return nil, fmt.Errorf("Повторяется имя типа записей: %s", tc.Name)
+		}
+		names[tc.Name] = true
+
+		packet, err := ldap.CompileFilter(tc.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("Неверный фильтр типа записей %s: %v", tc.Name, err)
+		}
+		if tc.Title == "" {
+			tc.Title = tc.Name
+		}
+		result = append(result, &entryType{EntryTypeConfig: tc, packet: packet})
+	}
+	return result, nil
+}
+
+// entryFilter возвращает фильтр всех показываемых записей: сотрудников
+// и записей дополнительных типов
+func entryFilter() string {
+	if len(entryTypes) == 0 {
+		return personFilter
+	}
+	filter := "(|" + personFilter
+	for _, t := range entryTypes {
+		filter += t.Filter
+	}
+	return filter + ")"
+}
+
+// classifyEntry определяет тип записи каталога, nil для сотрудников
+func classifyEntry(entry *ldap.Entry) *entryType {
+	for _, t := range entryTypes {
+		if matchFilter(entry, t.packet) {
+			return t
+		}
+	}
+	return nil
+}
+
+// entryTypeByName возвращает тип записей по имени, nil для сотрудников
+func entryTypeByName(name string) *entryType {
+	for _, t := range entryTypes {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// typeAttributes возвращает атрибуты, нужные для определения типа записей,
+// их колонок и карточек. Тип определяется по загруженным атрибутам,
+// поэтому запрашиваются все атрибуты из фильтров типов
+func typeAttributes() []string {
+	if len(entryTypes) == 0 {
+		return nil
+	}
+	attributes := []string{"objectClass"}
+	for _, t := range entryTypes {
+		attributes = append(attributes, filterAttributes(t.packet)...)
+		attributes = append(attributes, t.Columns...)
+		for _, m := range cardPlaceholder.FindAllStringSubmatch(t.Card, -1) {
+			attributes = append(attributes, m[1])
+		}
+	}
+	return attributes
+}
+
+// filterAttributes возвращает атрибуты, которые проверяет скомпилированный фильтр
+func filterAttributes(packet *ber.Packet) []string {
+	switch packet.Tag {
+	case ldap.FilterAnd, ldap.FilterOr, ldap.FilterNot:
+		var attributes []string
+		for _, child := range packet.Children {
+			attributes = append(attributes, filterAttributes(child)...)
+		}
+		return attributes
+
+	case ldap.FilterPresent:
+		return []string{ber.DecodeString(packet.Data.Bytes())}
+
+	case ldap.FilterSubstrings, ldap.FilterEqualityMatch, ldap.FilterApproxMatch, ldap.FilterGreaterOrEqual, ldap.FilterLessOrEqual:
+		if len(packet.Children) > 0 {
+			return []string{ber.DecodeString(packet.Children[0].Data.Bytes())}
+		}
+
+	case ldap.FilterExtensibleMatch:
+		// Атрибут необязателен и передается с тегом 2
+		for _, child := range packet.Children {
+			if child.Tag == 2 {
+				return []string{ber.DecodeString(child.Data.Bytes())}
+			}
+		}
+	}
+	return nil
+}
+
+// typedAttributes сохраняет атрибуты записи дополнительного типа
+func typedAttributes(entry *ldap.Entry) map[string]string {
+	result := map[string]string{}
+	for _, name := range typeAttributes() {
+		if value := entry.GetAttributeValue(name); value != "" {
+			result[strings.ToLower(name)] = value
+		}
+	}
+	return result
+}
+
+// entryAttribute возвращает значение атрибута записи по имени
+func entryAttribute(entry LDAPEntry, name string) string {
+	switch strings.ToLower(name) {
+	case "dn":
+		return entry.DN
+	case "cn":
+		return entry.CN
+	case "sn":
+		return entry.SN
+	case "givenname":
+		return entry.GivenName
+	case "initials":
+		return entry.Initials
+	case "mail":
+		return entry.Mail
+	case "telephonenumber":
+		return entry.TelephoneNumber
+	case "title":
+		return entry.Title
+	case "ou":
+		return entry.OU
+	case "o":
+		return entry.O
+	case "l":
+		return entry.L
+	case "postaladdress":
+		return entry.PostalAddress
+	}
+	return entry.Attributes[strings.ToLower(name)]
+}
+
+// columns возвращает значения колонок таблицы результатов для записи этого типа.
+// Колонки, для которых атрибут не задан, остаются как у сотрудников
+func (t *entryType) columns(entry LDAPEntry, values []string) []string {
+	for i, name := range t.Columns {
+		if i < len(values) && name != "" {
+			values[i] = entryAttribute(entry, name)
+		}
+	}
+	return values
+}
+
+// resultValues возвращает значения колонок таблицы результатов,
+// значок и название типа записи
+func resultValues(entry LDAPEntry) ([]string, string, string) {
+	values := []string{entry.CN, entry.TelephoneNumber, entry.Mail, entry.Title, entry.OU, entry.O}
+	if t := entryTypeByName(entry.Type); t != nil {
+		return t.columns(entry, values), t.Icon, t.Title
+	}
+	return values, "", ""
+}
+
+// card формирует карточку записи по шаблону
+func (t *entryType) card(entry LDAPEntry) string {
+	return cardPlaceholder.ReplaceAllStringFunc(t.Card, func(m string) string {
+		return entryAttribute(entry, m[1:len(m)-1])
+	})
+}
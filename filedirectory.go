@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/asn1-ber.v1"
+	"gopkg.in/ldap.v2"
+)
+
+// Классы объектов для записей из CSV и JSON, в которых они не указаны
+var defaultObjectClasses = []string{"top", "person", "organizationalPerson", "inetOrgPerson"}
+
+// Имена атрибутов в том написании, в котором их читает программа.
+// В файлах выгрузки регистр имен часто не совпадает
+var canonicalAttributes = map[string]string{}
+
+func init() {
+	for _, name := range []string{
+		"objectClass", "cn", "sn", "givenName", "initials", "displayName",
+		"mail", "telephoneNumber", "mobile", "title", "ou", "o", "l",
+		"postalAddress", "jpegPhoto", "manager", "member", "uniqueMember", "memberOf",
+	} {
+		canonicalAttributes[strings.ToLower(name)] = name
+	}
+}
+
+// fileDirectory выполняет поиск в локальном файле LDIF, CSV или JSON.
+// Файл перечитывается при изменении, поэтому его можно обновлять
+// на общем ресурсе без перезапуска программы
+type fileDirectory struct {
+	format string
+	path   string
+	baseDN string
+
+	mu      sync.Mutex
+	modTime time.Time
+	entries []*ldap.Entry
+}
+
+// load возвращает записи файла, перечитывая его при изменении
+func (d *fileDirectory) load() ([]*ldap.Entry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения файла справочника: %v", err)
+	}
+	if d.entries != nil && info.ModTime().Equal(d.modTime) {
+		return d.entries, nil
+	}
+
+	file, err := os.Open(d.path)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения файла справочника: %v", err)
+	}
+	defer file.Close()
+
+	var entries []*ldap.Entry
+	switch d.format {
+	case backendLDIF:
+		entries, err = parseLDIF(file)
+	case backendCSV:
+		entries, err = parseCSV(file, d.baseDN)
+	case backendJSON:
+		entries, err = parseJSON(file, d.baseDN)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка разбора файла справочника %s: %v", d.path, err)
+	}
+
+	d.entries = entries
+	d.modTime = info.ModTime()
+	return entries, nil
+}
+
+func (d *fileDirectory) Search(baseDN string, scope int, filter string, attributes []string) ([]*ldap.Entry, error) {
+	entries, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+
+	packet, err := ldap.CompileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	base := normalizeDN(baseDN)
+	var result []*ldap.Entry
+	found := false
+	for _, entry := range entries {
+		dn := normalizeDN(entry.DN)
+		if dn == base {
+			found = true
+		}
+		if inScope(dn, base, scope) && matchFilter(entry, packet) {
+			result = append(result, entry)
+		}
+	}
+
+	// Как и LDAP сервер, сообщаем об отсутствии записи при поиске по DN
+	if scope == ldap.ScopeBaseObject && !found {
+		return nil, ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("Запись отсутствует в файле: %s", baseDN))
+	}
+	return result, nil
+}
+
+func (d *fileDirectory) Values(baseDN string, filter string, attribute string) ([]string, error) {
+	entries, err := d.Search(baseDN, ldap.ScopeWholeSubtree, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Значения сравниваются без учета регистра, как на LDAP сервере
+	var values []string
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		found := attributeValues(entry, attribute)
+		if len(found) == 0 {
+			found = []string{""}
+		}
+		for _, value := range found {
+			if key := strings.ToLower(value); !seen[key] {
+				seen[key] = true
+				values = append(values, value)
+			}
+		}
+	}
+	return values, nil
+}
+
+// normalizeDN приводит DN к виду для сравнения: без пробелов и в нижнем регистре
+func normalizeDN(dn string) string {
+	parts := splitDN(dn)
+	for i, part := range parts {
+		if name, value, ok := strings.Cut(part, "="); ok {
+			part = strings.TrimSpace(name) + "=" + strings.TrimSpace(value)
+		}
+		parts[i] = strings.ToLower(strings.TrimSpace(part))
+	}
+	return strings.Join(parts, ",")
+}
+
+// splitDN делит DN на RDN по запятым, кроме экранированных, например
+// в "cn=Иванов\, Иван,dc=example"
+func splitDN(dn string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			// Экранированный символ пропускается
+			i++
+		case ',':
+			parts = append(parts, dn[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, dn[start:])
+}
+
+// inScope проверяет, попадает ли запись в область поиска.
+// DN записи и базы должны быть приведены normalizeDN
+func inScope(dn, base string, scope int) bool {
+	switch scope {
+	case ldap.ScopeBaseObject:
+		return dn == base
+	case ldap.ScopeSingleLevel:
+		parts := splitDN(dn)
+		return strings.Join(parts[1:], ",") == base
+	}
+	if base == "" || dn == base {
+		return true
+	}
+	parts, baseParts := splitDN(dn), splitDN(base)
+	return len(parts) > len(baseParts) && slices.Equal(parts[len(parts)-len(baseParts):], baseParts)
+}
+
+// attributeValues возвращает значения атрибута без учета регистра имени
+func attributeValues(entry *ldap.Entry, name string) []string {
+	for _, attr := range entry.Attributes {
+		if strings.EqualFold(attr.Name, name) {
+			return attr.Values
+		}
+	}
+	// DN записи, как на сервере, доступен в фильтре атрибутами entryDN и distinguishedName
+	if dnAttribute(name) {
+		return []string{entry.DN}
+	}
+	return nil
+}
+
+// dnAttribute проверяет, что атрибут содержит DN самой записи
+func dnAttribute(name string) bool {
+	return strings.EqualFold(name, "entryDN") || strings.EqualFold(name, "distinguishedName")
+}
+
+// matchValue приводит значение к виду для сравнения. Как и на сервере,
+// регистр не учитывается, в телефонах игнорируются пробелы и дефисы,
+// а DN сравниваются без пробелов вокруг "=" и ","
+func matchValue(attr, value string) string {
+	if dnAttribute(attr) {
+		return normalizeDN(value)
+	}
+	value = strings.ToLower(value)
+	if strings.EqualFold(attr, "telephoneNumber") || strings.EqualFold(attr, "mobile") {
+		value = strings.NewReplacer(" ", "", "-", "").Replace(value)
+	}
+	return value
+}
+
+// matchFilter проверяет запись по скомпилированному фильтру LDAP
+func matchFilter(entry *ldap.Entry, packet *ber.Packet) bool {
+	switch packet.Tag {
+	case ldap.FilterAnd:
+		for _, child := range packet.Children {
+			if !matchFilter(entry, child) {
+				return false
+			}
+		}
+		return true
+
+	case ldap.FilterOr:
+		for _, child := range packet.Children {
+			if matchFilter(entry, child) {
+				return true
+			}
+		}
+		return false
+
+	case ldap.FilterNot:
+		return len(packet.Children) == 1 && !matchFilter(entry, packet.Children[0])
+
+	case ldap.FilterPresent:
+		return len(attributeValues(entry, ber.DecodeString(packet.Data.Bytes()))) > 0
+
+	case ldap.FilterSubstrings:
+		attr := ber.DecodeString(packet.Children[0].Data.Bytes())
+		for _, value := range attributeValues(entry, attr) {
+			if matchSubstrings(matchValue(attr, value), attr, packet.Children[1].Children) {
+				return true
+			}
+		}
+		return false
+
+	case ldap.FilterEqualityMatch, ldap.FilterApproxMatch, ldap.FilterGreaterOrEqual, ldap.FilterLessOrEqual:
+		attr := ber.DecodeString(packet.Children[0].Data.Bytes())
+		assertion := matchValue(attr, ber.DecodeString(packet.Children[1].Data.Bytes()))
+		for _, value := range attributeValues(entry, attr) {
+			value = matchValue(attr, value)
+			switch packet.Tag {
+			case ldap.FilterGreaterOrEqual:
+				if value >= assertion {
+					return true
+				}
+			case ldap.FilterLessOrEqual:
+				if value <= assertion {
+					return true
+				}
+			default:
+				if value == assertion {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	// Расширенное сравнение (extensibleMatch) в файлах не поддерживается
+	return false
+}
+
+// matchSubstrings проверяет значение по шаблону вида начало*середина*конец
+func matchSubstrings(value, attr string, parts []*ber.Packet) bool {
+	for _, part := range parts {
+		s := matchValue(attr, ber.DecodeString(part.Data.Bytes()))
+		switch part.Tag {
+		case ldap.FilterSubstringsInitial:
+			if !strings.HasPrefix(value, s) {
+				return false
+			}
+			value = value[len(s):]
+		case ldap.FilterSubstringsAny:
+			i := strings.Index(value, s)
+			if i < 0 {
+				return false
+			}
+			value = value[i+len(s):]
+		case ldap.FilterSubstringsFinal:
+			if !strings.HasSuffix(value, s) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// attributeName приводит имя атрибута к принятому в программе написанию
+// и отбрасывает опции вида ";binary"
+func attributeName(name string) string {
+	name, _, _ = strings.Cut(strings.TrimSpace(name), ";")
+	if canonical, ok := canonicalAttributes[strings.ToLower(name)]; ok {
+		return canonical
+	}
+	return name
+}
+
+// newFileEntry создает запись из значений атрибутов файла. Если DN не указан,
+// он формируется из cn и base_dn, а отсутствующие классы объектов заполняются
+// как у обычного сотрудника
+func newFileEntry(dn string, attributes map[string][]string, baseDN string) *ldap.Entry {
+	if len(attributes["objectClass"]) == 0 {
+		attributes["objectClass"] = defaultObjectClasses
+	}
+	if dn == "" && len(attributes["cn"]) > 0 {
+		dn = "cn=" + escapeDNValue(attributes["cn"][0])
+		if baseDN != "" {
+			dn += "," + baseDN
+		}
+	}
+	return ldap.NewEntry(dn, attributes)
+}
+
+// escapeDNValue экранирует спецсимволы значения в DN (RFC 4514)
+func escapeDNValue(value string) string {
+	var b strings.Builder
+	for i, c := range value {
+		if strings.ContainsRune(`,+"\<>;=`, c) || c == '#' && i == 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// parseLDIF разбирает файл в формате LDIF (RFC 2849), например выгрузку slapcat
+func parseLDIF(r io.Reader) ([]*ldap.Entry, error) {
+	var entries []*ldap.Entry
+	var dn string
+	attributes := map[string][]string{}
+
+	// Строки записи с учетом переносов (продолжение начинается с пробела)
+	var lines []string
+	flush := func() error {
+		for _, line := range lines {
+			name, value, ok := strings.Cut(line, ":")
+			if !ok {
+				return fmt.Errorf("неверная строка: %s", line)
+			}
+			switch {
+			case strings.HasPrefix(value, ":"):
+				data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+				if err != nil {
+					return fmt.Errorf("неверное значение %s: %v", name, err)
+				}
+				value = string(data)
+			case strings.HasPrefix(value, "<"):
+				// Ссылки на внешние файлы не поддерживаются
+				continue
+			default:
+				value = strings.TrimPrefix(value, " ")
+			}
+
+			switch name = attributeName(name); strings.ToLower(name) {
+			case "dn":
+				dn = value
+			case "version", "changetype":
+			default:
+				attributes[name] = append(attributes[name], value)
+			}
+		}
+		if dn != "" {
+			entries = append(entries, ldap.NewEntry(dn, attributes))
+		}
+		dn = ""
+		attributes = map[string][]string{}
+		lines = nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, " "):
+			if len(lines) > 0 {
+				lines[len(lines)-1] += line[1:]
+			}
+		default:
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// parseCSV разбирает выгрузку из кадровой системы. Первая строка содержит
+// имена атрибутов LDAP, разделитель (запятая или точка с запятой) определяется
+// по ней. Несколько значений атрибута разделяются символом "|"
+func parseCSV(r io.Reader, baseDN string) ([]*ldap.Entry, error) {
+	reader := bufio.NewReader(r)
+	header, err := reader.Peek(4096)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, err
+	}
+	firstLine, _, _ := strings.Cut(string(header), "\n")
+
+	cr := csv.NewReader(reader)
+	if strings.Count(firstLine, ";") > strings.Count(firstLine, ",") {
+		cr.Comma = ';'
+	}
+	cr.FieldsPerRecord = -1
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	names := records[0]
+	for i, name := range names {
+		names[i] = attributeName(strings.TrimPrefix(name, "\ufeff"))
+	}
+
+	var entries []*ldap.Entry
+	for _, record := range records[1:] {
+		var dn string
+		attributes := map[string][]string{}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if i >= len(names) || value == "" {
+				continue
+			}
+			if strings.EqualFold(names[i], "dn") {
+				dn = value
+				continue
+			}
+			attributes[names[i]] = append(attributes[names[i]], strings.Split(value, "|")...)
+		}
+		if entry := newFileEntry(dn, attributes, baseDN); entry.DN != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// parseJSON разбирает массив объектов вида {"dn": "...", "cn": "...", "mail": ["...", "..."]}
+func parseJSON(r io.Reader, baseDN string) ([]*ldap.Entry, error) {
+	var records []map[string]any
+	decoder := json.NewDecoder(r)
+	// Телефоны, записанные числами, сохраняют исходный вид
+	decoder.UseNumber()
+	if err := decoder.Decode(&records); err != nil {
+		return nil, err
+	}
+
+	var entries []*ldap.Entry
+	for _, record := range records {
+		var dn string
+		attributes := map[string][]string{}
+
+		// Порядок ключей важен для повторяемости, если имена совпадут без учета регистра
+		keys := make([]string, 0, len(record))
+		for key := range record {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			var values []string
+			switch v := record[key].(type) {
+			case nil:
+			case []any:
+				for _, item := range v {
+					values = append(values, fmt.Sprint(item))
+				}
+			default:
+				values = append(values, fmt.Sprint(v))
+			}
+
+			if strings.EqualFold(key, "dn") {
+				if len(values) > 0 {
+					dn = values[0]
+				}
+				continue
+			}
+			if len(values) > 0 {
+				name := attributeName(key)
+				attributes[name] = append(attributes[name], values...)
+			}
+		}
+		if entry := newFileEntry(dn, attributes, baseDN); entry.DN != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
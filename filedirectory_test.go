@@ -0,0 +1,215 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/ldap.v2"
+)
+
+func TestMatchFilter(t *testing.T) {
+	entry := ldap.NewEntry("cn=Иванов Иван, ou=ИТ,dc=example", map[string][]string{
+		"objectClass":     {"top", "inetOrgPerson"},
+		"cn":              {"Иванов Иван"},
+		"mail":            {"ivanov@example.com", "i.ivanov@example.com"},
+		"telephoneNumber": {"+7 (495) 123-45-67"},
+		"title":           {"Инженер"},
+		"employeeNumber":  {"0042"},
+	})
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"(objectClass=inetOrgPerson)", true},
+		{"(objectclass=INETORGPERSON)", true},
+		{"(objectClass=group)", false},
+		{"(cn=*)", true},
+		{"(manager=*)", false},
+		{"(cn=иванов*)", true},
+		{"(cn=*иван)", true},
+		{"(cn=*ов*ив*)", true},
+		{"(cn=*ив*ов*ов*)", false},
+		{"(cn=Иванов*Петр)", false},
+		{"(mail=i.ivanov@example.com)", true},
+		{"(mail=*@example.org)", false},
+		{`(telephoneNumber=+7 \28495\29 1234567)`, true},
+		{"(telephoneNumber=*123-4567)", true},
+		{"(&(objectClass=inetOrgPerson)(title=инженер))", true},
+		{"(&(objectClass=inetOrgPerson)(title=бухгалтер))", false},
+		{"(|(title=бухгалтер)(mail=ivanov@*))", true},
+		{"(!(title=инженер))", false},
+		{"(!(title=бухгалтер))", true},
+		{"(employeeNumber>=0040)", true},
+		{"(employeeNumber<=0040)", false},
+		{"(title~=инженер)", true},
+		{"(entryDN=cn=иванов иван,ou=ит,dc=example)", true},
+		{"(distinguishedName=CN=Иванов Иван, OU=ИТ, DC=example)", true},
+		{"(entryDN=cn=Петров,dc=example)", false},
+		{"(cn:caseExactMatch:=Иванов Иван)", false},
+	}
+	for _, tt := range tests {
+		packet, err := ldap.CompileFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("CompileFilter(%q): %v", tt.filter, err)
+		}
+		if got := matchFilter(entry, packet); got != tt.want {
+			t.Errorf("matchFilter(%q) = %v, ожидалось %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestInScope(t *testing.T) {
+	tests := []struct {
+		dn, base string
+		scope    int
+		want     bool
+	}{
+		{"dc=example", "dc=example", ldap.ScopeBaseObject, true},
+		{"ou=ит,dc=example", "dc=example", ldap.ScopeBaseObject, false},
+		{"ou=ит,dc=example", "dc=example", ldap.ScopeSingleLevel, true},
+		{"cn=a,ou=ит,dc=example", "dc=example", ldap.ScopeSingleLevel, false},
+		{"dc=example", "dc=example", ldap.ScopeSingleLevel, false},
+		{"cn=a,ou=ит,dc=example", "dc=example", ldap.ScopeWholeSubtree, true},
+		{"dc=example", "dc=example", ldap.ScopeWholeSubtree, true},
+		{"cn=a,dc=other", "dc=example", ldap.ScopeWholeSubtree, false},
+		{"cn=a,dc=xexample", "dc=example", ldap.ScopeWholeSubtree, false},
+		{"cn=a,dc=example", "", ldap.ScopeWholeSubtree, true},
+		{`cn=иванов\, иван,dc=example`, "dc=example", ldap.ScopeSingleLevel, true},
+		{`cn=иванов\, иван,dc=example`, `иван,dc=example`, ldap.ScopeSingleLevel, false},
+		{`cn=a\,dc=example`, "dc=example", ldap.ScopeWholeSubtree, false},
+		{`cn=a,ou=b\,c,dc=example`, `ou=b\,c,dc=example`, ldap.ScopeSingleLevel, true},
+	}
+	for _, tt := range tests {
+		if got := inScope(tt.dn, tt.base, tt.scope); got != tt.want {
+			t.Errorf("inScope(%q, %q, %d) = %v, ожидалось %v", tt.dn, tt.base, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeDN(t *testing.T) {
+	tests := map[string]string{
+		"CN=Иванов Иван, OU=ИТ ,DC=Example": "cn=иванов иван,ou=ит,dc=example",
+		"cn = a,dc=b": "cn=a,dc=b",
+		`CN=Иванов\, Иван , DC=Example`: `cn=иванов\, иван,dc=example`,
+		`cn=a\\,dc=b`: `cn=a\\,dc=b`,
+		"":            "",
+	}
+	for dn, want := range tests {
+		if got := normalizeDN(dn); got != want {
+			t.Errorf("normalizeDN(%q) = %q, ожидалось %q", dn, got, want)
+		}
+	}
+}
+
+func TestParseLDIF(t *testing.T) {
+	data := `version: 1
+# комментарий
+dn: cn=Иванов Иван,dc=example
+objectclass: inetOrgPerson
+cn: Иванов Иван
+description: длинное значение, перенесенное
+  на следующую строку
+sn:: 0JjQstCw0L3QvtCy
+jpegPhoto:< file:///tmp/photo.jpg
+
+dn:: Y249UGV0cm92LGRjPWV4YW1wbGU=
+cn: Petrov
+`
+	entries, err := parseLDIF(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("разобрано записей: %d", len(entries))
+	}
+	first := entries[0]
+	checks := map[string]string{
+		"objectClass": "inetOrgPerson",
+		"cn":          "Иванов Иван",
+		"description": "длинное значение, перенесенное на следующую строку",
+		"sn":          "Иванов",
+		"jpegPhoto":   "",
+	}
+	for name, want := range checks {
+		if got := first.GetAttributeValue(name); got != want {
+			t.Errorf("%s = %q, ожидалось %q", name, got, want)
+		}
+	}
+	if entries[1].DN != "cn=Petrov,dc=example" {
+		t.Errorf("DN = %q", entries[1].DN)
+	}
+
+	if _, err := parseLDIF(strings.NewReader("dn: cn=a\nневерная строка\n")); err == nil {
+		t.Error("ожидалась ошибка для строки без двоеточия")
+	}
+}
+
+func TestFileDirectorySearch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "people.csv")
+	data := "cn;mail;ou;telephoneNumber\nИванов Иван;ivanov@example.com;ИТ;101|102\nПетров Петр;petrov@example.com;Склад;201\n\"Сидоров, Иван\";sidorov@example.com;ИТ;301\n"
+	if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := newDirectory(SourceConfig{Backend: backendCSV, DataFile: file, BaseDN: "dc=example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := dir.Search("dc=example", ldap.ScopeWholeSubtree, "(&(objectClass=inetOrgPerson)(telephoneNumber=102))", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].DN != "cn=Иванов Иван,dc=example" {
+		t.Errorf("найдено %d записей: %v", len(entries), entries)
+	}
+
+	// Запятая в CN экранируется в DN и не создает лишний уровень
+	entries, err = dir.Search("dc=example", ldap.ScopeSingleLevel, "(cn=Сидоров*)", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].DN != `cn=Сидоров\, Иван,dc=example` {
+		t.Errorf("поиск на один уровень: %v", entries)
+	}
+
+	if _, err := dir.Search("cn=Сидоров,dc=example", ldap.ScopeBaseObject, "(objectClass=*)", nil); !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		t.Errorf("поиск отсутствующей записи: %v", err)
+	}
+	if _, err := dir.Search("dc=example", ldap.ScopeWholeSubtree, "(cn=Иванов", nil); err == nil {
+		t.Error("ожидалась ошибка неверного фильтра")
+	}
+}
+
+func TestFileDirectoryValues(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "people.csv")
+	data := "cn;o;ou\nИванов;Рога;ИТ\nПетров;рога;Склад\nСидоров;Копыта;\n"
+	if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := newDirectory(SourceConfig{Backend: backendCSV, DataFile: file, BaseDN: "dc=example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter, attr string
+		want         []string
+	}{
+		{"(objectClass=*)", "o", []string{"Рога", "Копыта"}},
+		{"(o=Рога)", "ou", []string{"ИТ", "Склад"}},
+		{"(objectClass=*)", "ou", []string{"ИТ", "Склад", ""}},
+		{"(o=Нет)", "ou", nil},
+	}
+	for _, tt := range tests {
+		got, err := dir.Values("dc=example", tt.filter, tt.attr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Values(%q, %s) = %q, ожидалось %q", tt.filter, tt.attr, got, tt.want)
+		}
+	}
+}
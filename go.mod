@@ -4,8 +4,8 @@ go 1.24.2
 
 require (
 	github.com/dawidd6/go-appindicator v1.0.1
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/gotk3/gotk3 v0.6.1
+	gopkg.in/asn1-ber.v1 v1.0.0-20181015200546-f715ec2f112d
 	gopkg.in/ldap.v2 v2.5.1
 )
-
-require gopkg.in/asn1-ber.v1 v1.0.0-20181015200546-f715ec2f112d // indirect
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"gopkg.in/ldap.v2"
+)
+
+// Фильтр групп: списки рассылки OpenLDAP и группы Active Directory
+const groupFilter = "(|(objectClass=groupOfNames)(objectClass=groupOfUniqueNames)(objectClass=group))"
+
+// Сколько участников группы ищется одним запросом
+const groupMemberChunk = 50
+
+// Group группа или список рассылки
+type Group struct {
+	DN          string   `json:"dn"`
+	CN          string   `json:"cn"`
+	Description string   `json:"description,omitempty"`
+	Mail        string   `json:"mail,omitempty"`
+	Members     []string `json:"members,omitempty"`
+	Source      string   `json:"source,omitempty"`
+}
+
+// fetchGroups ищет группы во всех указанных источниках.
+// Ошибка возвращается, только если не ответил ни один источник
+func fetchGroups(srcs []*source, filter string) ([]Group, error) {
+	var result []Group
+	var firstErr error
+	failed := 0
+	seen := map[string]bool{}
+	for _, s := range srcs {
+		entries, err := s.dir.Search(s.BaseDN, ldap.ScopeWholeSubtree, filter, []string{"cn", "description", "mail", "member", "uniqueMember"})
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			if len(srcs) > 1 {
+				log.Printf("Источник %s: %v\n", s.Name, err)
+			}
+			continue
+		}
+		for _, entry := range entries {
+			if seen[strings.ToLower(entry.DN)] {
+				continue
+			}
+			seen[strings.ToLower(entry.DN)] = true
+			result = append(result, newGroup(entry, s.Name))
+		}
+	}
+	if len(srcs) > 0 && failed == len(srcs) {
+		return nil, fmt.Errorf("Ошибка поиска групп: %w", firstErr)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return strings.ToLower(result[i].CN) < strings.ToLower(result[j].CN)
+	})
+	return result, nil
+}
+
+// newGroup создает группу из записи каталога
+func newGroup(entry *ldap.Entry, sourceName string) Group {
+	g := Group{
+		DN:          entry.DN,
+		CN:          entry.GetAttributeValue("cn"),
+		Description: entry.GetAttributeValue("description"),
+		Mail:        entry.GetAttributeValue("mail"),
+		Source:      sourceName,
+	}
+	if g.CN == "" {
+		g.CN = dnName(entry.DN)
+	}
+	g.Members = append(g.Members, entry.GetAttributeValues("member")...)
+	for _, member := range entry.GetAttributeValues("uniqueMember") {
+		// uniqueMember может содержать необязательный UID после "#"
+		if i := strings.LastIndex(member, "#"); i > 0 {
+			member = member[:i]
+		}
+		g.Members = append(g.Members, member)
+	}
+	return g
+}
+
+// fetchPersonGroups возвращает группы, в которые входит сотрудник:
+// по атрибутам member и uniqueMember групп и по атрибуту memberOf сотрудника
+func fetchPersonGroups(entry LDAPEntry) ([]Group, error) {
+	dn := escapeFilter(entry.DN)
+	groups, err := fetchGroups(sources, "(&"+groupFilter+"(|(member="+dn+")(uniqueMember="+dn+")(uniqueMember="+dn+"#*)))")
+	if err != nil {
+		return nil, err
+	}
+
+	// Группы из memberOf, которые не нашлись поиском, например в другом домене
+	found := map[string]bool{}
+	for _, g := range groups {
+		found[strings.ToLower(g.DN)] = true
+	}
+	for _, dn := range entry.MemberOf {
+		if !found[strings.ToLower(dn)] {
+			found[strings.ToLower(dn)] = true
+			groups = append(groups, Group{DN: dn, CN: dnName(dn)})
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return strings.ToLower(groups[i].CN) < strings.ToLower(groups[j].CN)
+	})
+	return groups, nil
+}
+
+// fetchGroupMembers возвращает сотрудников группы. Участники ищутся
+// по DN частями по groupMemberChunk в одном запросе: атрибут entryDN есть
+// в OpenLDAP, distinguishedName - в Active Directory. Вложенные группы
+// и участники, отсутствующие в справочнике, пропускаются
+func fetchGroupMembers(g Group) ([]LDAPEntry, error) {
+	var result []LDAPEntry
+	var firstErr error
+	seen := map[string]bool{}
+	for start := 0; start < len(g.Members); start += groupMemberChunk {
+		filter := "(|"
+		for _, dn := range g.Members[start:min(start+groupMemberChunk, len(g.Members))] {
+			dn = escapeFilter(dn)
+			filter += "(entryDN=" + dn + ")(distinguishedName=" + dn + ")"
+		}
+		entries, err := fetchPeople(filter + ")")
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, entry := range entries {
+			if dn := normalizeDN(entry.DN); !seen[dn] {
+				seen[dn] = true
+				result = append(result, entry)
+			}
+		}
+	}
+	if len(result) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CN < result[j].CN
+	})
+	return result, nil
+}
+
+// groupTitle возвращает название группы вместе с адресом рассылки
+func groupTitle(g Group) string {
+	if g.Mail != "" {
+		return g.CN + " <" + g.Mail + ">"
+	}
+	return g.CN
+}
+
+// formatPersonGroups формирует раздел "Группы" карточки сотрудника
+func formatPersonGroups(groups []Group) string {
+	var b strings.Builder
+	b.WriteString("Группы:")
+	for _, g := range groups {
+		b.WriteString("\n  " + groupTitle(g))
+	}
+	return b.String()
+}
+
+// formatGroupDetails формирует описание группы для панели детальной информации
+func formatGroupDetails(g Group, members int) string {
+	details := "Группа: " + g.CN
+	if g.Description != "" {
+		details += "\nОписание: " + g.Description
+	}
+	if g.Mail != "" {
+		details += "\nEmail: " + g.Mail
+	}
+	details += fmt.Sprintf("\nУчастников: %d (сотрудников в справочнике: %d)\nDN: %s", len(g.Members), members, g.DN)
+	if g.Source != "" {
+		details += "\nИсточник: " + g.Source
+	}
+	return details
+}
+
+// showPersonGroups дополняет показанную карточку сотрудника списком его групп.
+// Карточка не меняется, если за время поиска выбрано что-то другое
+func showPersonGroups(entry LDAPEntry, details string) {
+	groups, err := fetchPersonGroups(entry)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(groups) == 0 {
+		return
+	}
+	glib.IdleAdd(func() {
+		start, end := detailsBuffer.GetBounds()
+		if text, err := detailsBuffer.GetText(start, end, false); err != nil || text != details {
+			return
+		}
+		setDetailsText(details + "\n" + formatPersonGroups(groups))
+	})
+}
+
+// showGroupBrowser открывает окно списка групп. Выбранная группа
+// показывается в таблице результатов списком участников
+func showGroupBrowser() {
+	window, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	if err != nil {
+		fmt.Printf("Ошибка создания окна: %v\n", err)
+		return
+	}
+	window.SetTitle("Группы и списки рассылки")
+	window.SetTransientFor(mainWindow)
+	window.SetDefaultSize(450, 600)
+
+	box, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 5)
+	if err != nil {
+		fmt.Printf("Ошибка создания контейнера: %v\n", err)
+		return
+	}
+
+	filterEntry, err := gtk.SearchEntryNew()
+	if err != nil {
+		fmt.Printf("Ошибка создания фильтра групп: %v\n", err)
+		return
+	}
+	filterEntry.SetPlaceholderText("Фильтр групп...")
+
+	store, err := gtk.ListStoreNew(
+		glib.TYPE_STRING, // Название
+		glib.TYPE_STRING, // Email
+		glib.TYPE_STRING, // Описание
+		glib.TYPE_INT,    // Номер группы в списке, не показывается
+	)
+	if err != nil {
+		fmt.Printf("Ошибка создания модели групп: %v\n", err)
+		return
+	}
+	view, err := gtk.TreeViewNewWithModel(store)
+	if err != nil {
+		fmt.Printf("Ошибка создания списка групп: %v\n", err)
+		return
+	}
+	addResizableColumn(view, "Группа", 0)
+	addResizableColumn(view, "Email", 1)
+	addResizableColumn(view, "Описание", 2)
+
+	scrolled, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		fmt.Printf("Ошибка создания прокручиваемой области: %v\n", err)
+		return
+	}
+	scrolled.Add(view)
+
+	box.PackStart(filterEntry, false, false, 0)
+	box.PackStart(scrolled, true, true, 0)
+	window.Add(box)
+
+	var groups []Group
+	// DN группы, участники которой показываются
+	var shownGroup string
+	fill := func() {
+		text, _ := filterEntry.GetText()
+		text = strings.ToLower(strings.TrimSpace(text))
+		store.Clear()
+		for i, g := range groups {
+			if text != "" && !strings.Contains(strings.ToLower(g.CN+" "+g.Mail+" "+g.Description), text) {
+				continue
+			}
+			store.Set(store.Append(), []int{0, 1, 2, 3}, []any{g.CN, g.Mail, g.Description, i})
+		}
+	}
+	filterEntry.Connect("search-changed", fill)
+
+	view.Connect("cursor-changed", func() {
+		selection, err := view.GetSelection()
+		if err != nil {
+			return
+		}
+		model, iter, ok := selection.GetSelected()
+		if !ok {
+			return
+		}
+		value, err := model.(*gtk.TreeModel).GetValue(iter, 3)
+		if err != nil {
+			return
+		}
+		index, err := value.GoValue()
+		if err != nil {
+			return
+		}
+		g := groups[index.(int)]
+		if g.DN == shownGroup {
+			return
+		}
+		shownGroup = g.DN
+
+		go func() {
+			members, err := fetchGroupMembers(g)
+			glib.IdleAdd(func() {
+				// Пока шел поиск, могла быть выбрана другая группа
+				if shownGroup != g.DN {
+					return
+				}
+				if err != nil {
+					shownGroup = ""
+					showErrorDialog(err.Error())
+					return
+				}
+				showPeople(members)
+				setDetailsText(formatGroupDetails(g, len(members)))
+				setAttributesDN(g.DN)
+			})
+		}()
+	})
+
+	window.ShowAll()
+
+	go func() {
+		result, err := fetchGroups(sources, groupFilter)
+		glib.IdleAdd(func() {
+			if err != nil {
+				showErrorDialog(err.Error())
+				return
+			}
+			groups = result
+			fill()
+		})
+	}()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Общий для всех пользователей сокет прежних версий
+const legacySocketFile = "/tmp/ldap-phonebook.sock"
+
+// Файл блокировки, удерживаемый запущенным экземпляром
+var instanceLock *os.File
+
+// defaultSocketFile возвращает путь к сокету текущего пользователя:
+// $XDG_RUNTIME_DIR/ldap-phonebook.sock или /tmp/ldap-phonebook-<uid>/ldap-phonebook.sock
+func defaultSocketFile() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, appName+".sock")
+	}
+	return filepath.Join(tempSocketDir(), appName+".sock")
+}
+
+// tempSocketDir возвращает личный каталог пользователя для сокета во временном каталоге
+func tempSocketDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("%s-%d", appName, os.Getuid()))
+}
+
+// prepareSocketDir создает каталог сокета. Каталог во временном каталоге,
+// доступном всем пользователям, должен принадлежать текущему пользователю
+// и иметь права 0700, иначе другой пользователь мог бы занять сокет и блокировку
+func prepareSocketDir() error {
+	dir := filepath.Dir(config.SocketFile)
+	if dir != tempSocketDir() {
+		return os.MkdirAll(dir, 0700)
+	}
+
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s не является каталогом", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("каталог %s принадлежит другому пользователю", dir)
+	}
+	if info.Mode().Perm() != 0700 {
+		return fmt.Errorf("у каталога %s права %o вместо 0700", dir, info.Mode().Perm())
+	}
+	return nil
+}
+
+func lockFile() string {
+	return config.SocketFile + ".lock"
+}
+
+// acquireInstanceLock захватывает блокировку экземпляра программы.
+// Возвращает false, если блокировку уже держит другой процесс
+func acquireInstanceLock() (bool, error) {
+	if err := prepareSocketDir(); err != nil {
+		return false, err
+	}
+
+	file, err := os.OpenFile(lockFile(), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return false, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		file.Close()
+		return false, nil
+	}
+	if err != nil {
+		file.Close()
+		return false, err
+	}
+
+	// Блокировка освобождается при завершении процесса
+	instanceLock = file
+	return true, nil
+}
+
+// releaseInstanceLock освобождает блокировку экземпляра
+func releaseInstanceLock() {
+	if instanceLock != nil {
+		instanceLock.Close()
+		instanceLock = nil
+	}
+}
+
+// dialInstance подключается к сокету запущенного экземпляра
+// и проверяет, что он принадлежит текущему пользователю
+func dialInstance() (net.Conn, error) {
+	conn, err := net.Dial("unix", config.SocketFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPeerCredentials(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// checkPeerCredentials проверяет через SO_PEERCRED, что процесс
+// на другой стороне сокета запущен тем же пользователем
+func checkPeerCredentials(conn net.Conn) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("соединение не является Unix socket")
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return err
+	}
+	if credErr != nil {
+		return credErr
+	}
+
+	if int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("процесс %d принадлежит другому пользователю (uid %d)", cred.Pid, cred.Uid)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Интеграция с dmenu/rofi/fzf:
+//
+//	ldap-phonebook dmenu | rofi -dmenu -i | ldap-phonebook pick --copy phone
+//	ldap-phonebook dmenu | fzf | ldap-phonebook pick --open
+
+// launcherLine формирует строку записи для меню выбора. DN записи
+// добавляется после табуляции, чтобы различать однофамильцев
+// и находить запись, даже если кэш обновился после вывода меню
+func launcherLine(e LDAPEntry) string {
+	dept := e.OU
+	if dept == "" {
+		dept = e.O
+	}
+	return strings.Join([]string{e.CN, e.TelephoneNumber, e.Mail, dept}, " — ") + "\t" + e.DN
+}
+
+// cliDmenu выводит всех сотрудников из кэша, по строке на запись
+func cliDmenu(args []string) int {
+	flags := flag.NewFlagSet("dmenu", flag.ContinueOnError)
+	refresh := flags.Bool("refresh", false, "обновить кэш из LDAP")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	entries, err := cachedPeople(*refresh)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitServerError
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	for _, e := range entries {
+		fmt.Fprintln(w, launcherLine(e))
+	}
+	w.Flush()
+	return exitOK
+}
+
+// cliPick принимает строку, выбранную в меню, и копирует телефон/email
+// в буфер обмена или открывает карточку в запущенной программе
+func cliPick(args []string) int {
+	flags := flag.NewFlagSet("pick", flag.ContinueOnError)
+	copyField := flags.String("copy", "phone", "что скопировать в буфер обмена: phone или email")
+	open := flags.Bool("open", false, "открыть карточку в запущенной программе")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Использование: %s pick [--copy phone|email] [--open] [строка]\n", appName)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	// Выбранная строка передается аргументом или через stdin
+	line := strings.Join(flags.Args(), " ")
+	if line == "" {
+		scanner := bufio.NewScanner(os.Stdin)
+		if scanner.Scan() {
+			line = scanner.Text()
+		}
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		// Выбор в меню отменен
+		return exitNotFound
+	}
+
+	entry, err := pickEntry(line)
+	if errors.Is(err, errNotFound) {
+		fmt.Fprintf(os.Stderr, "Запись не найдена: %s\n", line)
+		return exitNotFound
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitServerError
+	}
+
+	if *open {
+		if _, err := sendInstanceCommand(controlRequest{Command: "show", DN: entry.DN}); err != nil {
+			fmt.Fprintf(os.Stderr, "Ошибка передачи команды: %v\n", err)
+			return exitServerError
+		}
+		sendInstanceCommand(controlRequest{Command: "activate"})
+		return exitOK
+	}
+
+	var text string
+	switch *copyField {
+	case "phone":
+		text = entry.TelephoneNumber
+	case "email":
+		text = entry.Mail
+	default:
+		flags.Usage()
+		return exitUsage
+	}
+	if text == "" {
+		fmt.Fprintf(os.Stderr, "У записи не заполнено поле %s\n", *copyField)
+		return exitNotFound
+	}
+
+	if err := copyToClipboard(text); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitServerError
+	}
+	return exitOK
+}
+
+// pickEntry находит запись строки меню по DN в кэше без его обновления,
+// чтобы запись не пропала, если кэш устарел, пока было открыто меню.
+// Записи, которой уже нет в кэше, ищется на сервере
+func pickEntry(line string) (*LDAPEntry, error) {
+	_, dn, ok := strings.Cut(line, "\t")
+	if !ok || dn == "" {
+		return nil, errNotFound
+	}
+	if entries, err := readPeopleCache(); err == nil {
+		for i := range entries {
+			if entries[i].DN == dn {
+				return &entries[i], nil
+			}
+		}
+	}
+
+	entry, err := fetchPerson(dn)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// copyToClipboard копирует текст в буфер обмена с помощью wl-copy/xclip/xsel,
+// а если их нет - через запущенную программу
+func copyToClipboard(text string) error {
+	var tools [][]string
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append(tools, []string{"wl-copy"})
+	}
+	tools = append(tools,
+		[]string{"xclip", "-selection", "clipboard"},
+		[]string{"xsel", "--clipboard", "--input"},
+	)
+
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(tool[0], tool[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err == nil {
+			return nil
+		}
+	}
+
+	if _, err := sendInstanceCommand(controlRequest{Command: "copy", Text: text}); err != nil {
+		return fmt.Errorf("Не удалось скопировать в буфер обмена: %v", err)
+	}
+	return nil
+}
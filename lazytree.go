@@ -0,0 +1,470 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"gopkg.in/ldap.v2"
+)
+
+// Строка-заглушка в еще не загруженных узлах дерева
+const loadingTitle = "Загрузка..."
+
+// Фильтр записей каталога, которые становятся узлами дерева по DN
+const containerFilter = "(|(objectClass=organization)(objectClass=organizationalUnit)(objectClass=container)(objectClass=domain))"
+
+// lazyTree проверяет, что дерево источника можно загружать по уровням.
+// Дерево по DN с группировкой по городам строится по записям сотрудников
+// и загружается целиком
+func (s *source) lazyTree(grouping string) bool {
+	return !s.treeFromDN || grouping != groupingLocation
+}
+
+// lazyRoot создает корень дерева источника для загрузки по уровням
+func (s *source) lazyRoot(grouping string) *OrgNode {
+	return &OrgNode{
+		Name:     s.title(grouping),
+		Children: make(map[string]*OrgNode),
+		Key:      s.nodeKey(nil, treeLevel{}),
+		Total:    -1,
+		grouping: grouping,
+		lazy:     true,
+	}
+}
+
+// fetchChildren загружает вложенные узлы следующего уровня. Дерево по DN
+// загружается поиском на один уровень, остальные - по различным значениям
+// атрибута следующего уровня в пределах узла. Численность узлов не
+// загружается. Группировка берется из узла, поэтому смена группировки
+// во время загрузки не влияет на результат
+func (s *source) fetchChildren(node *OrgNode) (map[string]*OrgNode, error) {
+	if s.treeFromDN && node.grouping == groupingOrg {
+		return s.fetchDNChildren(node)
+	}
+
+	depth := len(node.path)
+	var levels []lazyLevel
+	var err error
+	switch node.grouping {
+	case groupingLocation:
+		if depth == 0 {
+			levels, err = s.groupLevels(node, "l", true)
+		} else {
+			levels, err = s.structureLevels(node, depth-1)
+		}
+	case groupingTitle:
+		levels, err = s.groupLevels(node, "title", false)
+	case groupingAddress:
+		levels, err = s.groupLevels(node, "postalAddress", false)
+	default:
+		levels, err = s.structureLevels(node, depth)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[string]*OrgNode)
+	for _, level := range levels {
+		if _, exists := children[level.Name]; exists {
+			continue
+		}
+		names := append(append([]string{}, node.path...), level.Name)
+		children[level.Name] = &OrgNode{
+			Name:     level.Name,
+			Children: make(map[string]*OrgNode),
+			Key:      s.nodeKey(names, level.treeLevel),
+			Filter:   level.Filter,
+			Total:    -1,
+			scope:    level.scope,
+			path:     names,
+			grouping: node.grouping,
+			lazy:     level.lazy,
+		}
+	}
+	return children, nil
+}
+
+// lazyLevel вложенный узел, загружаемый по значению атрибута
+type lazyLevel struct {
+	treeLevel
+	// Фильтр людей узла без собственного фильтра
+	scope string
+	// У узла могут быть вложенные узлы
+	lazy bool
+}
+
+// scopeFilter возвращает фильтр людей узла вместе с вложенными узлами
+func (n *OrgNode) scopeFilter() string {
+	if n.Filter != "" {
+		return n.Filter
+	}
+	return n.scope
+}
+
+// andFilter добавляет условие к фильтру узла
+func andFilter(filter, condition string) string {
+	if filter == "" {
+		return condition
+	}
+	return "(&" + filter + condition + ")"
+}
+
+// groupLevels загружает узлы верхнего уровня группировки по атрибуту attr.
+// inner - под узлами есть уровни оргструктуры
+func (s *source) groupLevels(node *OrgNode, attr string, inner bool) ([]lazyLevel, error) {
+	values, err := s.levelValues(node.scopeFilter(), attr)
+	if err != nil {
+		return nil, err
+	}
+	var levels []lazyLevel
+	for _, value := range values {
+		value = strings.TrimSpace(quotRemove(value))
+		level := treeLevel{Name: value, Filter: "(" + attr + "=" + escapeFilter(value) + ")"}
+		if value == "" {
+			level = treeLevel{Name: noValueTitle, Filter: "(!(" + attr + "=*))"}
+		}
+		levels = append(levels, lazyLevel{treeLevel: level, lazy: inner})
+	}
+	return levels, nil
+}
+
+// structureLevels загружает узлы уровня depth оргструктуры под узлом node.
+// Уровни строятся так же, как пути записей в structurePath
+func (s *source) structureLevels(node *OrgNode, depth int) ([]lazyLevel, error) {
+	scope := node.scopeFilter()
+
+	// Уровни по списку атрибутов из конфига
+	if attributes := s.treePathAttributes; len(attributes) > 0 {
+		if depth >= len(attributes) {
+			return nil, nil
+		}
+		attr := attributes[depth]
+		values, err := s.levelValues(scope, attr)
+		if err != nil {
+			return nil, err
+		}
+		var levels []lazyLevel
+		for _, value := range values {
+			// Путь записи заканчивается на первом незаполненном атрибуте
+			if value = strings.TrimSpace(quotRemove(value)); value == "" {
+				continue
+			}
+			levels = append(levels, lazyLevel{
+				treeLevel: treeLevel{Name: value, Filter: andFilter(scope, "("+attr+"="+escapeFilter(value)+")")},
+				lazy:      depth+1 < len(attributes),
+			})
+		}
+		return levels, nil
+	}
+
+	// Атрибуты "o" вида "Организация, Подразделение" и "ou"
+	switch depth {
+	case 0:
+		values, err := s.levelValues(scope, "o")
+		if err != nil {
+			return nil, err
+		}
+		var levels []lazyLevel
+		for _, o := range values {
+			orgName := strings.TrimSpace(strings.SplitN(quotRemove(o), ",", 2)[0])
+			if orgName == "" {
+				continue
+			}
+			// Для уровня организации люди не показываются
+			org := escapeFilter(orgName)
+			levels = append(levels, lazyLevel{
+				treeLevel: treeLevel{Name: orgName},
+				scope:     andFilter(scope, "(|(o="+org+")(o="+org+",*))"),
+				lazy:      true,
+			})
+		}
+		return levels, nil
+
+	case 1:
+		values, err := s.levelValues(scope, "o")
+		if err != nil {
+			return nil, err
+		}
+		var levels []lazyLevel
+		for _, o := range values {
+			o = quotRemove(o)
+			orgParts := strings.SplitN(o, ",", 2)
+			if !strings.EqualFold(strings.TrimSpace(orgParts[0]), node.Name) {
+				continue
+			}
+			filter := andFilter(scope, "(o="+escapeFilter(o)+")")
+			if len(orgParts) > 1 && strings.TrimSpace(orgParts[1]) != "" {
+				// Подразделение организации
+				levels = append(levels, lazyLevel{
+					treeLevel: treeLevel{Name: strings.TrimSpace(orgParts[1]), Filter: filter},
+					lazy:      true,
+				})
+				continue
+			}
+			// Отделы непосредственно в организации
+			departments, err := s.departmentLevels(filter)
+			if err != nil {
+				return nil, err
+			}
+			levels = append(levels, departments...)
+		}
+		return levels, nil
+
+	case 2:
+		return s.departmentLevels(scope)
+	}
+	return nil, nil
+}
+
+// departmentLevels загружает отделы "ou" людей, подходящих под фильтр
+func (s *source) departmentLevels(filter string) ([]lazyLevel, error) {
+	values, err := s.levelValues(filter, "ou")
+	if err != nil {
+		return nil, err
+	}
+	var levels []lazyLevel
+	for _, ou := range values {
+		if ou = strings.TrimSpace(quotRemove(ou)); ou == "" {
+			continue
+		}
+		levels = append(levels, lazyLevel{
+			treeLevel: treeLevel{Name: ou, Filter: andFilter(filter, "(ou="+escapeFilter(ou)+")")},
+		})
+	}
+	return levels, nil
+}
+
+// Кэш значений атрибутов, загруженных для дерева по уровням. Значения
+// хранятся столько же, сколько локальный кэш сотрудников, поэтому
+// перезагрузка дерева и смена группировки не повторяют запросы.
+// Кэш сбрасывается командой reload
+var treeValues = struct {
+	sync.Mutex
+	items map[string]treeValuesItem
+}{items: map[string]treeValuesItem{}}
+
+// treeValuesItem значения атрибута и время их загрузки
+type treeValuesItem struct {
+	values []string
+	loaded time.Time
+}
+
+// levelValues возвращает различные значения атрибута attr у людей,
+// подходящих под фильтр узла, из кэша или из каталога
+func (s *source) levelValues(filter, attr string) ([]string, error) {
+	key := s.Name + "\x00" + filter + "\x00" + attr
+	treeValues.Lock()
+	item, ok := treeValues.items[key]
+	treeValues.Unlock()
+	if ok && time.Since(item.loaded) < cacheTTL() {
+		return item.values, nil
+	}
+
+	values, err := s.dir.Values(s.BaseDN, andFilter(filter, entryFilter()), attr)
+	if err != nil {
+		return nil, err
+	}
+	treeValues.Lock()
+	treeValues.items[key] = treeValuesItem{values: values, loaded: time.Now()}
+	treeValues.Unlock()
+	return values, nil
+}
+
+// clearTreeValues сбрасывает кэш значений атрибутов дерева
+func clearTreeValues() {
+	treeValues.Lock()
+	treeValues.items = map[string]treeValuesItem{}
+	treeValues.Unlock()
+}
+
+// fetchDNChildren загружает вложенные организации и подразделения
+// каталога. Узлы, у которых по операционным атрибутам нет вложенных
+// записей, сразу отмечаются загруженными, чтобы в дереве у них
+// не было стрелки раскрытия
+func (s *source) fetchDNChildren(node *OrgNode) (map[string]*OrgNode, error) {
+	base := node.Base
+	if base == "" {
+		base = s.BaseDN
+	}
+	entries, err := s.dir.Search(base, ldap.ScopeSingleLevel, containerFilter, []string{"hasSubordinates", "numSubordinates"})
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[string]*OrgNode)
+	for _, entry := range entries {
+		parsed, err := ldap.ParseDN(entry.DN)
+		if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+			continue
+		}
+		name := parsed.RDNs[0].Attributes[0].Value
+		names := append(append([]string{}, node.path...), name)
+		children[name] = &OrgNode{
+			Name:     name,
+			Children: make(map[string]*OrgNode),
+			Key:      s.nodeKey(names, treeLevel{Base: entry.DN}),
+			Base:     entry.DN,
+			Total:    -1,
+			path:     names,
+			grouping: node.grouping,
+			lazy:     !noSubordinates(entry),
+		}
+	}
+	return children, nil
+}
+
+// noSubordinates проверяет по атрибутам hasSubordinates и numSubordinates,
+// что у записи нет вложенных записей. Если сервер их не возвращает,
+// считается, что вложенные записи могут быть
+func noSubordinates(entry *ldap.Entry) bool {
+	if has := entry.GetAttributeValue("hasSubordinates"); has != "" {
+		return strings.EqualFold(has, "FALSE")
+	}
+	return entry.GetAttributeValue("numSubordinates") == "0"
+}
+
+// setChildren сохраняет загруженные вложенные узлы
+func (n *OrgNode) setChildren(children map[string]*OrgNode) {
+	n.Children = children
+	n.lazy = false
+}
+
+// expandLazyRow загружает вложенные узлы развернутой строки дерева.
+// Вызывается только из основного потока GTK
+func expandLazyRow(iter *gtk.TreeIter) {
+	store := treeStore()
+	if store == nil {
+		return
+	}
+	key := getKeyIter(store, iter)
+	src, node := orgNodeByKey(key)
+	if node == nil || !node.lazy || node.loading {
+		return
+	}
+
+	node.loading = true
+	go func() {
+		children, err := src.fetchChildren(node)
+		glib.IdleAdd(func() {
+			node.loading = false
+			// Дерево могло быть перезагружено, пока шел поиск
+			if _, current := orgNodeByKey(key); current != node {
+				return
+			}
+			if err != nil {
+				showErrorDialog(err.Error())
+				return
+			}
+			node.setChildren(children)
+			syncTreeRow(node)
+		})
+	}()
+}
+
+// syncTreeRow заменяет вложенные строки узла дерева загруженными узлами.
+// Вызывается только из основного потока GTK
+func syncTreeRow(node *OrgNode) {
+	store := treeStore()
+	if store == nil {
+		return
+	}
+	iter, ok := findTreeKey(store, nil, node.Key)
+	if !ok {
+		return
+	}
+	store.SetValue(iter, 1, headcountText(node))
+
+	// Старые строки удаляются после добавления новых, чтобы узел не свернулся
+	old := store.IterNChildren(iter)
+	for _, child := range sortedChildren(node) {
+		populateTreeStore(store, iter, child)
+	}
+	var child gtk.TreeIter
+	for i := 0; i < old && store.IterChildren(iter, &child); i++ {
+		store.Remove(&child)
+	}
+}
+
+// revealTreePath загружает незагруженные узлы дерева по пути от корня источника
+// и показывает их в дереве. Возвращает true, если что-то было загружено.
+// Вызывается не из основного потока GTK
+func revealTreePath(names []string) bool {
+	var topNode *OrgNode
+	for i := range names {
+		var src *source
+		var node *OrgNode
+		var lazy bool
+		runOnMain(func() {
+			src, node = orgNodeByNames(names[:i+1])
+			lazy = node != nil && node.lazy && !node.loading
+		})
+		if node == nil {
+			break
+		}
+		if !lazy {
+			continue
+		}
+
+		children, err := src.fetchChildren(node)
+		if err != nil {
+			log.Printf("Узел %s: %v\n", node.Name, err)
+			break
+		}
+		runOnMain(func() {
+			node.setChildren(children)
+		})
+		if topNode == nil {
+			topNode = node
+		}
+	}
+
+	if topNode == nil {
+		return false
+	}
+	runOnMain(func() {
+		syncTreeRow(topNode)
+	})
+	return true
+}
+
+// revealInSource загружает узлы по пути в дереве источника с заданным именем
+func revealInSource(sourceName string, parts []string) bool {
+	var title string
+	runOnMain(func() {
+		for _, root := range orgTrees {
+			if s := sourceOfRoot(root); s != nil && s.Name == sourceName {
+				title = root.Name
+			}
+		}
+	})
+	if title == "" {
+		return false
+	}
+	return revealTreePath(append([]string{title}, parts...))
+}
+
+// revealByPath загружает узлы по пути вида "Организация:Отдел",
+// который может начинаться с имени источника
+func revealByPath(pathStr string) {
+	parts := strings.Split(pathStr, ":")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+
+	var titles []string
+	runOnMain(func() {
+		for _, root := range orgTrees {
+			titles = append(titles, root.Name)
+		}
+	})
+	for _, title := range titles {
+		if title == parts[0] {
+			revealTreePath(parts)
+		}
+		revealTreePath(append([]string{title}, parts...))
+	}
+}
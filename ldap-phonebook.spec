@@ -27,6 +27,8 @@ mkdir -p %{buildroot}/opt/filial/bin
 mkdir -p %{buildroot}/etc/ldap-phonebook
 mkdir -p %{buildroot}/usr/share/icons
 mkdir -p %{buildroot}/usr/share/applications
+mkdir -p %{buildroot}/usr/share/dbus-1/services
+mkdir -p %{buildroot}/usr/share/gnome-shell/search-providers
 
 install -m 755 %{name} %{buildroot}/opt/filial/bin/%{name}
 install -m 644 %{name}.json %{buildroot}/etc/ldap-phonebook/
@@ -44,6 +46,22 @@ Type=Application
 Categories=Office;Utility;Network;
 EOF
 
+# D-Bus activation
+cat > %{buildroot}/usr/share/dbus-1/services/io.github.imax1000.LdapPhonebook.service <<EOF
+[D-BUS Service]
+Name=io.github.imax1000.LdapPhonebook
+Exec=/opt/filial/bin/%{name} --gapplication-service
+EOF
+
+# GNOME Shell search provider
+cat > %{buildroot}/usr/share/gnome-shell/search-providers/%{name}-search-provider.ini <<EOF
+[Shell Search Provider]
+DesktopId=%{name}.desktop
+BusName=io.github.imax1000.LdapPhonebook
+ObjectPath=/io/github/imax1000/LdapPhonebook/SearchProvider
+Version=2
+EOF
+
 %postun
 # Удаляем пустые директории после удаления пакета
 if [ $1 -eq 0 ]; then
@@ -85,6 +103,8 @@ fi
 %attr(644,root,root) /etc/ldap-phonebook/%{name}.json
 %attr(644,root,root) /usr/share/icons/%{name}.ico
 %attr(644,root,root) /usr/share/applications/%{name}.desktop
+%attr(644,root,root) /usr/share/dbus-1/services/io.github.imax1000.LdapPhonebook.service
+%attr(644,root,root) /usr/share/gnome-shell/search-providers/%{name}-search-provider.ini
 
 %changelog
 * Thu May 01 2025 Maxim Izvekov <maximizvekov@yandex.ru> - %{version}-1
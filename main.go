@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 
 	"log"
@@ -14,6 +16,7 @@ import (
 	"sort"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/dawidd6/go-appindicator"
 	"github.com/gotk3/gotk3/gdk"
@@ -30,6 +33,26 @@ type Config struct {
 	BaseDN       string `json:"base_dn"`
 	SocketFile   string `json:"socket_file"`
 	Debug        bool   `json:"debug_mode"`
+	PopupTimeout int    `json:"popup_timeout"`
+	CacheTTL     int    `json:"cache_ttl"`
+	// Источник данных: ldap, ldif, csv или json
+	Backend string `json:"backend"`
+	// Файл справочника для источников ldif, csv и json
+	DataFile string `json:"data_file"`
+	// Атрибуты уровней дерева сверху вниз, по умолчанию "o" и "ou"
+	TreeAttributes []string `json:"tree_attributes,omitempty"`
+	// Строить дерево по DN записей (ou=...,o=...)
+	TreeFromDN bool `json:"tree_from_dn,omitempty"`
+	// Загружать дерево по уровням при разворачивании узлов
+	LazyTree bool `json:"lazy_tree,omitempty"`
+	// Регулярное выражение для должности руководителя в сводке по отделу
+	HeadTitlePattern string `json:"head_title_pattern,omitempty"`
+	// Дополнительные типы записей: переговорные, общие ящики и т.п.
+	EntryTypes []EntryTypeConfig `json:"entry_types,omitempty"`
+	// Атрибуты, по которым ищет строка поиска
+	SearchAttributes []string `json:"search_attributes,omitempty"`
+	// Несколько именованных источников вместо основных параметров подключения
+	Sources []SourceConfig `json:"sources,omitempty"`
 }
 
 var (
@@ -39,33 +62,69 @@ var (
 	treeView      *gtk.TreeView
 	searchEntry   *gtk.Entry
 	resultsView   *gtk.TreeView
+	matchColumn   *gtk.TreeViewColumn
 	detailsView   *gtk.TextView
 	detailsBuffer *gtk.TextBuffer
 	indicator     *appindicator.Indicator
 	searchResult  []LDAPEntry
+	// Деревья источников, показанные в левой панели
+	orgTrees []*OrgNode
+	// Запрос из командной строки, выполняемый после загрузки дерева
+	startupRequest *controlRequest
 )
 
 // LDAPEntry represents a single LDAP entry from the LDIF file
 type LDAPEntry struct {
-	DN              string
-	ObjectClass     string
-	SN              string
-	CN              string
-	OU              string
-	Title           string
-	Mail            string
-	GivenName       string
-	Initials        string
-	TelephoneNumber string
-	L               string
-	PostalAddress   string
-	O               string
+	DN              string `json:"dn"`
+	ObjectClass     string `json:"objectClass,omitempty"`
+	SN              string `json:"sn,omitempty"`
+	CN              string `json:"cn"`
+	OU              string `json:"ou,omitempty"`
+	Title           string `json:"title,omitempty"`
+	Mail            string `json:"mail,omitempty"`
+	GivenName       string `json:"givenName,omitempty"`
+	Initials        string `json:"initials,omitempty"`
+	TelephoneNumber string `json:"telephoneNumber,omitempty"`
+	L               string `json:"l,omitempty"`
+	PostalAddress   string `json:"postalAddress,omitempty"`
+	O               string `json:"o,omitempty"`
+	Manager         string `json:"manager,omitempty"`
+	// Группы сотрудника из атрибута memberOf (Active Directory)
+	MemberOf []string `json:"memberOf,omitempty"`
+	// Тип записи, если это не сотрудник, и атрибуты для ее колонок и карточки
+	Type       string            `json:"type,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Имя источника данных, если их несколько
+	Source string `json:"source,omitempty"`
+	// Поля, совпавшие со строкой поиска
+	Match string `json:"match,omitempty"`
+	// Путь к узлу записи в дереве источника и ключ этого узла
+	TreePath []string `json:"-"`
+	TreeKey  string   `json:"-"`
 }
 
 // OrgNode represents a node in the organizational tree
 type OrgNode struct {
 	Name     string
 	Children map[string]*OrgNode
+	// Ключ узла: DN для дерева по DN, иначе путь от корня источника
+	Key string
+	// Фильтр и база поиска людей узла, пустые для узлов без списка людей
+	Filter string
+	Base   string
+	// Число сотрудников в самом узле и вместе с вложенными,
+	// Total меньше нуля, если численность неизвестна
+	Direct int
+	Total  int
+
+	// Загрузка дерева по уровням: фильтр людей узла без собственного
+	// фильтра, путь от корня источника, группировка дерева
+	// и признак незагруженных вложенных узлов
+	scope    string
+	path     []string
+	grouping string
+	lazy     bool
+	loading  bool
 }
 
 const (
@@ -76,13 +135,61 @@ const (
 
 func main() {
 
+	// Консольные команды выполняются без инициализации GTK
+	if len(os.Args) > 1 {
+		if _, ok := cliCommands[os.Args[1]]; ok {
+			loadConfig()
+			os.Exit(runCLI(os.Args[1], os.Args[2:]))
+		}
+	}
+
+	incoming := flag.String("incoming", "", "показать карточку звонящего по номеру телефона")
+	search := flag.String("search", "", "найти сотрудников по ФИО, email или телефону")
+	showDept := flag.String("show-dept", "", "показать сотрудников отдела, путь вида \"Организация:Отдел\"")
+	service := flag.Bool("gapplication-service", false, "запуск через D-Bus без показа главного окна")
+	flag.Parse()
+
+	// Запрос из командной строки: ldap-phonebook "Иванов"
+	if *search == "" && flag.NArg() > 0 {
+		*search = strings.Join(flag.Args(), " ")
+	}
+	if *search != "" {
+		startupRequest = &controlRequest{Command: "search", Query: *search}
+	} else if *showDept != "" {
+		startupRequest = &controlRequest{Command: "select-department", Path: *showDept}
+	}
+
 	// Загружаем конфигурацию
 	loadConfig()
 
+	// Входящий звонок: передаем номер запущенному экземпляру
+	// или показываем уведомление самостоятельно
+	if *incoming != "" {
+		if isAlreadyRunning() {
+			if _, err := sendInstanceCommand(controlRequest{Command: "incoming", Number: *incoming}); err != nil {
+				fmt.Printf("Ошибка передачи команды: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		releaseInstanceLock()
+		releaseBusName()
+		gtk.Init(nil)
+		go handleIncomingCall(*incoming)
+		gtk.Main()
+		os.Exit(0)
+	}
+
 	// Проверяем, не запущен ли уже экземпляр программы
 	if isAlreadyRunning() {
 		fmt.Println("Программа уже запущена. Активируем существующий экземпляр...")
 		activateExistingInstance()
+		if startupRequest != nil {
+			if _, err := sendInstanceCommand(*startupRequest); err != nil {
+				fmt.Printf("Ошибка выполнения запроса: %v\n", err)
+				os.Exit(1)
+			}
+		}
 		os.Exit(0)
 	}
 
@@ -98,11 +205,17 @@ func main() {
 	// Запускаем Unix socket сервер
 	go startUnixSocketServer()
 
-	// Показываем все виджеты
-	mainWindow.ShowAll()
+	// Публикуем интерфейс на сессионной шине D-Bus
+	exportDBusObjects()
+
+	// Показываем все виджеты. При запуске через D-Bus (например, поисковым
+	// провайдером GNOME Shell) окно остается скрытым до активации
+	if !*service {
+		mainWindow.ShowAll()
+	}
 
 	// Загружаем данные из LDAP
-	go loadLDAPData()
+	go loadLDAPData(currentGrouping)
 
 	// Главный цикл GTK
 	gtk.Main()
@@ -145,8 +258,11 @@ func loadConfig() {
 			//			BindPassword: "ro_pass",
 			BindPassword: "",
 			BaseDN:       "dc=mail,dc=local",
-			SocketFile:   "/tmp/ldap-phonebook.sock",
+			SocketFile:   "",
 			Debug:        false,
+			PopupTimeout: defaultPopupTimeout,
+			CacheTTL:     defaultCacheTTL,
+			Backend:      backendLDAP,
 		}
 
 		configPath = filepath.Join(os.Getenv("HOME"), ".config", appName, configFile)
@@ -158,6 +274,23 @@ func loadConfig() {
 		os.WriteFile(configPath, data, 0644)
 	}
 
+	// Сокет по умолчанию у каждого пользователя свой
+	if config.SocketFile == "" || config.SocketFile == legacySocketFile {
+		config.SocketFile = defaultSocketFile()
+	}
+
+	var err error
+	sources, err = newSources(config)
+	if err != nil {
+		fmt.Printf("Ошибка конфига %s: %v\n", configPath, err)
+		os.Exit(1)
+	}
+	entryTypes, err = newEntryTypes(config.EntryTypes)
+	if err != nil {
+		fmt.Printf("Ошибка конфига %s: %v\n", configPath, err)
+		os.Exit(1)
+	}
+
 }
 
 func createMainWindow() {
@@ -204,7 +337,11 @@ func createMainWindow() {
 	}
 
 	// Настройка модели дерева
-	treeStore, err := gtk.TreeStoreNew(glib.TYPE_STRING)
+	treeStore, err := gtk.TreeStoreNew(
+		glib.TYPE_STRING, // Название узла
+		glib.TYPE_STRING, // Численность
+		glib.TYPE_STRING, // Ключ узла, не показывается
+	)
 	if err != nil {
 		fmt.Printf("Ошибка создания модели дерева: %v\n", err)
 		os.Exit(1)
@@ -223,13 +360,30 @@ func createMainWindow() {
 		os.Exit(1)
 	}
 
-	column, err := gtk.TreeViewColumnNewWithAttribute("Организации и отделы", renderer, "text", 0)
+	column, err := gtk.TreeViewColumnNewWithAttribute(currentGroupingTitle(), renderer, "text", 0)
 	if err != nil {
 		fmt.Printf("Ошибка создания колонки: %v\n", err)
 		os.Exit(1)
 	}
 
 	treeView.AppendColumn(column)
+
+	// Колонка численности: в самом узле / вместе с вложенными
+	countRenderer, err := gtk.CellRendererTextNew()
+	if err != nil {
+		fmt.Printf("Ошибка создания рендерера: %v\n", err)
+		os.Exit(1)
+	}
+	countRenderer.SetProperty("xalign", 1.0)
+
+	countColumn, err := gtk.TreeViewColumnNewWithAttribute("Сотр.", countRenderer, "text", 1)
+	if err != nil {
+		fmt.Printf("Ошибка создания колонки: %v\n", err)
+		os.Exit(1)
+	}
+	treeView.AppendColumn(countColumn)
+	column.SetExpand(true)
+
 	treeView.SetEnableSearch(false)
 
 	treeView.SetSearchColumn(0)
@@ -262,13 +416,59 @@ func createMainWindow() {
 				treeView.CollapseAll()
 			})
 
+			exportItem, err := gtk.MenuItemNewWithLabel("Экспорт схемы подчиненности...")
+			if err != nil {
+				return
+			}
+			exportItem.Connect("activate", exportDepartmentChart)
+
 			menu.Append(expandItem)
 			menu.Append(collapseItem)
+			menu.Append(exportItem)
 			menu.ShowAll()
 			menu.PopupAtPointer(ev)
 		}
 	})
 
+	// Список способов группировки над деревом
+	groupingCombo, err := gtk.ComboBoxTextNew()
+	if err != nil {
+		fmt.Printf("Ошибка создания списка группировки: %v\n", err)
+		os.Exit(1)
+	}
+	for _, g := range groupings {
+		groupingCombo.Append(g.ID, g.Title)
+	}
+	groupingCombo.SetActiveID(currentGrouping)
+	groupingCombo.SetTooltipText("Группировка дерева")
+	groupingCombo.Connect("changed", func() {
+		currentGrouping = groupingCombo.GetActiveID()
+		column.SetTitle(currentGroupingTitle())
+		go loadLDAPData(currentGrouping)
+	})
+	leftPanel.PackStart(groupingCombo, false, false, 0)
+
+	// Загрузка вложенных узлов при разворачивании
+	treeView.Connect("row-expanded", func(v *gtk.TreeView, iter *gtk.TreeIter, path *gtk.TreePath) {
+		expandLazyRow(iter)
+	})
+
+	// Фильтр узлов дерева
+	treeFilterEntry, err := gtk.SearchEntryNew()
+	if err != nil {
+		fmt.Printf("Ошибка создания фильтра дерева: %v\n", err)
+		os.Exit(1)
+	}
+	treeFilterEntry.SetPlaceholderText("Фильтр отделов...")
+	treeFilterEntry.Connect("search-changed", func() {
+		text, err := treeFilterEntry.GetText()
+		if err != nil {
+			return
+		}
+		applyTreeFilter(text)
+	})
+	leftPanel.PackStart(treeFilterEntry, false, false, 0)
+
 	// Добавляем дерево в прокручиваемую область
 	scrolledWindow, err := gtk.ScrolledWindowNew(nil, nil)
 	if err != nil {
@@ -324,7 +524,8 @@ func createMainWindow() {
 	searchEntry.SetProperty("secondary-icon-stock", "gtk-clear")
 	searchEntry.SetProperty("secondary-icon-tooltip-text", "Очистить поиск")
 
-	searchEntry.SetPlaceholderText("Поиск по ФИО, email, телефону...")
+	searchEntry.SetPlaceholderText("Поиск по ФИО, email, телефону, отделу, городу...")
+	searchEntry.SetTooltipText("Поля: title:инженер dept:\"ИТ отдел\" city:Москва -mail:*test*\nФильтр LDAP: (&(title=*инженер*)(l=Москва))")
 
 	searchButton, err := gtk.ButtonNewWithLabel("Поиск")
 	if err != nil {
@@ -344,6 +545,20 @@ func createMainWindow() {
 	exitButton.SetProperty("label", "gtk-quit")
 	exitButton.SetProperty("use-stock", true)
 
+	advancedButton, err := gtk.ButtonNewWithLabel("Расширенный...")
+	if err != nil {
+		fmt.Printf("Ошибка создания кнопки расширенного поиска: %v\n", err)
+		os.Exit(1)
+	}
+	advancedButton.SetTooltipText("Расширенный поиск по полям")
+
+	groupsButton, err := gtk.ButtonNewWithLabel("Группы")
+	if err != nil {
+		fmt.Printf("Ошибка создания кнопки групп: %v\n", err)
+		os.Exit(1)
+	}
+	groupsButton.SetTooltipText("Группы и списки рассылки")
+
 	helpButton, err := gtk.ButtonNewWithLabel("?")
 	if err != nil {
 		fmt.Printf("Ошибка создания кнопки помощи: %v\n", err)
@@ -359,6 +574,8 @@ func createMainWindow() {
 
 	searchBox.PackStart(searchEntry, true, true, 0)
 	searchBox.PackStart(searchButton, false, false, 0)
+	searchBox.PackStart(advancedButton, false, false, 0)
+	searchBox.PackStart(groupsButton, false, false, 0)
 	searchBox.PackStart(exitButton, false, false, 0)
 	searchBox.PackStart(helpButton, false, false, 0)
 
@@ -390,6 +607,10 @@ func createMainWindow() {
 		glib.TYPE_STRING, // Должность
 		glib.TYPE_STRING, // Отдел
 		glib.TYPE_STRING, // Организация
+		glib.TYPE_STRING, // Источник
+		glib.TYPE_STRING, // Значок типа записи
+		glib.TYPE_STRING, // Тип записи
+		glib.TYPE_STRING, // Совпавшие поля
 	)
 	if err != nil {
 		fmt.Printf("Ошибка создания модели результатов: %v\n", err)
@@ -407,6 +628,38 @@ func createMainWindow() {
 	addResizableColumn(resultsView, "Должность", 3)
 	addResizableColumn(resultsView, "Отдел", 4)
 	addResizableColumn(resultsView, "Организация", 5)
+	addResizableColumn(resultsView, "Источник", 6)
+
+	addResizableColumn(resultsView, "Совпадение", 9)
+
+	// Колонка источника нужна, только если их несколько
+	resultsView.GetColumn(6).SetVisible(len(sources) > 1)
+	matchColumn = resultsView.GetColumn(7)
+
+	// Колонка типа записи нужна, только если настроены типы кроме сотрудников
+	typeColumn, err := gtk.TreeViewColumnNew()
+	if err != nil {
+		fmt.Printf("Ошибка создания колонки: %v\n", err)
+		os.Exit(1)
+	}
+	typeColumn.SetTitle("Тип")
+	typeColumn.SetResizable(true)
+	iconRenderer, err := gtk.CellRendererPixbufNew()
+	if err != nil {
+		fmt.Printf("Ошибка создания рендерера: %v\n", err)
+		os.Exit(1)
+	}
+	typeColumn.PackStart(iconRenderer, false)
+	typeColumn.AddAttribute(iconRenderer, "icon-name", 7)
+	typeRenderer, err := gtk.CellRendererTextNew()
+	if err != nil {
+		fmt.Printf("Ошибка создания рендерера: %v\n", err)
+		os.Exit(1)
+	}
+	typeColumn.PackStart(typeRenderer, true)
+	typeColumn.AddAttribute(typeRenderer, "text", 8)
+	resultsView.InsertColumn(typeColumn, 0)
+	typeColumn.SetVisible(len(entryTypes) > 0)
 
 	// Прокручиваемая область для результатов
 	resultsScrolled, err := gtk.ScrolledWindowNew(nil, nil)
@@ -468,7 +721,33 @@ func createMainWindow() {
 	}
 
 	detailsScrolled.Add(detailsView)
-	detailsBox.PackStart(detailsScrolled, true, true, 0)
+
+	// Вкладки: карточка и все атрибуты записи
+	detailsNotebook, err := gtk.NotebookNew()
+	if err != nil {
+		fmt.Printf("Ошибка создания вкладок: %v\n", err)
+		os.Exit(1)
+	}
+	cardLabel, err := gtk.LabelNew("Карточка")
+	if err != nil {
+		fmt.Printf("Ошибка создания метки: %v\n", err)
+		os.Exit(1)
+	}
+	detailsNotebook.AppendPage(detailsScrolled, cardLabel)
+
+	attributesBox, err := newAttributesPage()
+	if err != nil {
+		fmt.Printf("Ошибка создания вкладки атрибутов: %v\n", err)
+		os.Exit(1)
+	}
+	attributesLabel, err := gtk.LabelNew("Все атрибуты")
+	if err != nil {
+		fmt.Printf("Ошибка создания метки: %v\n", err)
+		os.Exit(1)
+	}
+	detailsNotebook.AppendPage(attributesBox, attributesLabel)
+
+	detailsBox.PackStart(detailsNotebook, true, true, 0)
 
 	// Устанавливаем минимальный размер для нижней панели
 	detailsBox.SetSizeRequest(-1, 150)
@@ -487,6 +766,7 @@ func createMainWindow() {
 	resultsScrolled.SetSizeRequest(-1, 350)
 	// Добавляем главный контейнер в окно
 	mainWindow.Add(mainPaned)
+	mainPaned.ShowAll()
 
 	// Настройка обработчиков событий
 	// Обработка сигналов для корректного завершения
@@ -504,6 +784,12 @@ func createMainWindow() {
 	// Обработка нажатия кнопки О программе
 	helpButton.Connect("clicked", showAboutDialog)
 
+	// Обработка нажатия кнопки расширенного поиска
+	advancedButton.Connect("clicked", showAdvancedSearch)
+
+	// Обработка нажатия кнопки Группы
+	groupsButton.Connect("clicked", showGroupBrowser)
+
 	// Обработка нажатия кнопки поиска
 	searchButton.Connect("clicked", func() {
 		go performSearch()
@@ -524,6 +810,39 @@ func createMainWindow() {
 		go onDepartmentSelected()
 	})
 
+	// Контекстное меню для результатов поиска
+	resultsView.Connect("button-press-event", func(v *gtk.TreeView, ev *gdk.Event) bool {
+		event := gdk.EventButtonNewFromEvent(ev)
+		if event.Button() != 3 { // Правая кнопка мыши
+			return false
+		}
+		path, _, _, _, ok := resultsView.GetPathAtPos(int(event.X()), int(event.Y()))
+		if !ok {
+			return false
+		}
+		index := path.GetIndices()[0]
+		if index >= len(searchResult) {
+			return false
+		}
+		entry := searchResult[index]
+
+		menu, err := gtk.MenuNew()
+		if err != nil {
+			return false
+		}
+		chartItem, err := gtk.MenuItemNewWithLabel("Подчиненность...")
+		if err != nil {
+			return false
+		}
+		chartItem.Connect("activate", func() {
+			showOrgChart(entry)
+		})
+		menu.Append(chartItem)
+		menu.ShowAll()
+		menu.PopupAtPointer(ev)
+		return true
+	})
+
 	// Обработка выбора в результатах поиска
 	resultsView.Connect("row-activated", func() {
 		go onPersonSelected()
@@ -747,75 +1066,20 @@ func quotAdd(str string) string {
 	return str
 	// return strings.Replace(strings.Replace(str, "'", "&#039;", -1), "\"", "&quot;", -1)
 }
-func buildOrgTree(entries []*ldap.Entry) *OrgNode {
-	root := &OrgNode{
-		Name:     "Организации и отделы",
-		Children: make(map[string]*OrgNode),
-	}
-
-	for _, entry := range entries {
-		str := quotRemove(entry.GetAttributeValue("o"))
-
-		orgParts := strings.SplitN(str, ",", 2)
-		orgName := strings.TrimSpace(orgParts[0])
-		var deptName string
-		if len(orgParts) > 1 {
-			deptName = strings.TrimSpace(orgParts[1])
-		}
-
-		// Find or create organization node
-		orgNode, exists := root.Children[orgName]
-		if !exists {
-			orgNode = &OrgNode{
-				Name:     orgName,
-				Children: make(map[string]*OrgNode),
-			}
-			root.Children[orgName] = orgNode
-		}
-
-		// Handle department and OU
-		if deptName != "" {
-			// Organization has departments
-			deptNode, exists := orgNode.Children[deptName]
-			if !exists {
-				deptNode = &OrgNode{
-					Name:     deptName,
-					Children: make(map[string]*OrgNode),
-				}
-				orgNode.Children[deptName] = deptNode
-			}
-
-			// Add OU under department
-			deptName = quotRemove(entry.GetAttributeValue("ou"))
-			if deptName != "" {
-				if _, exists := deptNode.Children[deptName]; !exists {
-					deptNode.Children[deptName] = &OrgNode{
-						Name:     deptName,
-						Children: make(map[string]*OrgNode),
-					}
-				}
-			}
-		} else {
-			deptName = quotRemove(entry.GetAttributeValue("ou"))
-			// Organization has no departments, add OU directly under org
-			if deptName != "" {
-				if _, exists := orgNode.Children[deptName]; !exists {
-					orgNode.Children[deptName] = &OrgNode{
-						Name:     deptName,
-						Children: make(map[string]*OrgNode),
-					}
-				}
-			}
-		}
-	}
-
-	return root
-}
 
 // Helper function to populate tree store
 func populateTreeStore(store *gtk.TreeStore, parent *gtk.TreeIter, node *OrgNode) {
 	iter := store.Append(parent)
 	store.SetValue(iter, 0, node.Name)
+	store.SetValue(iter, 1, headcountText(node))
+	store.SetValue(iter, 2, node.Key)
+
+	// Вложенные узлы загружаются при разворачивании
+	if node.lazy {
+		placeholder := store.Append(iter)
+		store.SetValue(placeholder, 0, loadingTitle)
+		return
+	}
 
 	var s []string
 	for _, child := range node.Children {
@@ -832,39 +1096,13 @@ func populateTreeStore(store *gtk.TreeStore, parent *gtk.TreeIter, node *OrgNode
 
 }
 
-func loadLDAPData() {
-	// Подключаемся к LDAP серверу
-	l, err := ldap.Dial("tcp", config.LDAPServer)
-	if err != nil {
-		glib.IdleAdd(func() {
-			showErrorDialog("Ошибка подключения к LDAP серверу: " + err.Error())
-		})
-		return
-	}
-	defer l.Close()
-
-	// Аутентификация
-	err = l.Bind(config.BindDN, config.BindPassword)
-	if err != nil {
-		glib.IdleAdd(func() {
-			showErrorDialog("Ошибка аутентификации в LDAP: " + err.Error())
-		})
-		return
-	}
-
-	// Поиск организаций
-	searchRequest := ldap.NewSearchRequest(
-		config.BaseDN,
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		"(objectClass=inetOrgPerson)",
-		[]string{"o", "ou"},
-		nil,
-	)
-
-	sr, err := l.Search(searchRequest)
+// loadLDAPData загружает дерево с группировкой grouping, переданной
+// из основного потока GTK
+func loadLDAPData(grouping string) {
+	roots, err := fetchOrgTrees(config.LazyTree, grouping)
 	if err != nil {
 		glib.IdleAdd(func() {
-			showErrorDialog("Ошибка поиска организаций: " + err.Error())
+			showErrorDialog(err.Error())
 		})
 		return
 	}
@@ -891,25 +1129,23 @@ func loadLDAPData() {
 			return
 		}
 
-		// Очищаем дерево
-		store.Clear()
-		/////////////////////////////////////////////////////////////////////////////////////
-
-		populateTreeStore(store, nil, buildOrgTree(sr.Entries))
-
-		// Добавляем организации и отделы
-		for _, entry := range sr.Entries {
-			orgName := entry.GetAttributeValue("o")
-			//			orgName := quotRemove(entry.GetAttributeValue("o"))
-			if orgName == "" {
-				continue
-			}
-
+		// Дерево с прежней группировкой загрузилось после смены группировки
+		if grouping != currentGrouping {
+			return
+		}
+		orgTrees = roots
+		shownGrouping.Store(grouping)
+		// Заполняем дерево заново с учетом фильтра
+		refreshTree(store)
+
+		emitContactsChanged()
+
+		// Выполняем запрос из командной строки
+		if startupRequest != nil {
+			req := *startupRequest
+			startupRequest = nil
+			go runStartupRequest(req)
 		}
-		// Раскрытие первого уровня
-		iter, _ := store.GetIterFirst()
-		path, _ := store.GetPath(iter)
-		treeView.ExpandRow(path, false)
 		/*
 			// Раскрытие второго уровня
 			iter, err = store.GetIterFromString("0:0")
@@ -926,127 +1162,125 @@ func loadLDAPData() {
 	})
 }
 
-func onDepartmentSelected() {
+// runStartupRequest выполняет запрос, переданный в командной строке при запуске
+func runStartupRequest(req controlRequest) {
+	if _, err := runControlCommand(req); err != nil {
+		glib.IdleAdd(func() {
+			showErrorDialog(err.Error())
+		})
+	}
+}
 
-	selection, err := treeView.GetSelection()
+// fetchOrgTree загружает из источника дерево организаций и отделов.
+// При загрузке по уровням загружается только первый уровень
+func (s *source) fetchOrgTree(lazy bool, grouping string) (*OrgNode, error) {
+	if lazy && s.lazyTree(grouping) {
+		root := s.lazyRoot(grouping)
+		children, err := s.fetchChildren(root)
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка поиска организаций: %v", err)
+		}
+		root.setChildren(children)
+		return root, nil
+	}
+
+	// Атрибуты типов записей нужны, чтобы не считать переговорные
+	// и общие ящики сотрудниками. Для дерева по DN без типов записей
+	// атрибуты не нужны
+	attributes := append(append([]string{}, s.treeAttributes(grouping)...), typeAttributes()...)
+	if len(attributes) == 0 {
+		attributes = []string{"1.1"}
+	}
+
+	// Поиск организаций
+	entries, err := s.dir.Search(s.BaseDN, ldap.ScopeWholeSubtree, entryFilter(), attributes)
 	if err != nil {
-		return
+		return nil, fmt.Errorf("Ошибка поиска организаций: %v", err)
 	}
 
-	model, iter, ok := selection.GetSelected()
-	if !ok {
-		return
+	return s.buildOrgTree(entries, grouping), nil
+}
+
+func onDepartmentSelected() {
+	src, node := selectedTreeNode()
+	if node != nil && node.searchable() {
+		searchPeople(src, node)
 	}
+}
 
-	// Получаем путь к выбранному элементу
-	path, err := model.(*gtk.TreeModel).GetPath(iter)
+// selectedTreeNode возвращает источник и узел дерева, выбранный в левой панели
+func selectedTreeNode() (*source, *OrgNode) {
+
+	selection, err := treeView.GetSelection()
 	if err != nil {
-		return
+		return nil, nil
+	}
+
+	_, iter, ok := selection.GetSelected()
+	if !ok {
+		return nil, nil
 	}
 
 	// Получаем модель
-	model, err = treeView.GetModel()
+	model, err := treeView.GetModel()
 	if err != nil {
 		log.Println("Ошибка модели:", err)
-		return
+		return nil, nil
 	}
 
 	// Приводим к TreeStore
 	treeStore, ok := model.(*gtk.TreeStore)
 	if !ok {
 		log.Println("Неверный тип модели")
-		return
-	}
-
-	itemName, err := getTextIter(model.(*gtk.TreeStore), iter)
-	//	value, err := model.(*gtk.TreeStore).GetValue(iter, 0)
-	if err != nil {
-		return
+		return nil, nil
 	}
-	if config.Debug {
-		fmt.Println("Выбран элемент: " + itemName)
-	}
-
-	// Определяем уровень вложенности
-	depth := path.GetDepth()
 
+	key := getKeyIter(treeStore, iter)
 	if config.Debug {
-		fmt.Printf("Уровень: %d\n", depth)
+		fmt.Printf("Ключ элемента: %q\n", key)
 	}
+	return orgNodeByKey(key)
+}
 
-	if depth <= 2 {
-		return
+// orgNodeByKey ищет источник и узел дерева по ключу узла
+func orgNodeByKey(key string) (*source, *OrgNode) {
+	if key == "" {
+		return nil, nil
 	}
-
-	//	itemName, _ := value.GetString()
-
-	var rootName, parentName string
-	// Проверяем родителя
-	var parentIter gtk.TreeIter
-	if treeStore.IterParent(&parentIter, iter) {
-		// Получаем значение из колонки 0 (текст)
-		value, err := treeStore.GetValue(&parentIter, 0)
-		if err != nil {
-			log.Println("Ошибка значения:", err)
-			return
-		}
-
-		// Извлекаем строку
-		parentName, err = value.GetString()
-		if err != nil {
-			log.Println("Ошибка преобразования:", err)
-			return
-		}
-		if config.Debug {
-			fmt.Printf("Текст родителя: %s\n", parentName)
+	for _, root := range orgTrees {
+		if node := findOrgNodeByKey(root, key); node != nil {
+			return sourceOfRoot(root), node
 		}
 	}
-	// Проверяем корень
-	var rootIter gtk.TreeIter
-	if treeStore.IterParent(&rootIter, &parentIter) {
-		// Получаем значение из колонки 0 (текст)
-		value, err := treeStore.GetValue(&rootIter, 0)
-		if err != nil {
-			log.Println("Ошибка значения:", err)
-			return
-		}
+	return nil, nil
+}
 
-		// Извлекаем строку
-		rootName, err = value.GetString()
-		if err != nil {
-			log.Println("Ошибка преобразования:", err)
-			return
-		}
-		//		fmt.Printf("Текст родителя: %s\n", parentName)
+// findOrgNodeByKey ищет узел с заданным ключом в дереве
+func findOrgNodeByKey(node *OrgNode, key string) *OrgNode {
+	if node.Key == key {
+		return node
 	}
-	if config.Debug {
-		fmt.Printf("Путь элемента: %s->%s->%s\n", rootName, parentName, itemName)
+	for _, child := range node.Children {
+		if found := findOrgNodeByKey(child, key); found != nil {
+			return found
+		}
 	}
+	return nil
+}
 
-	hasChildren := treeStore.IterHasChild(iter)
-	if config.Debug {
-		fmt.Printf("Элемент имеет дочерние элементы: %v\n", hasChildren)
+// orgNodeByNames ищет источник и узел дерева по названиям от корня источника
+func orgNodeByNames(names []string) (*source, *OrgNode) {
+	if len(names) == 0 {
+		return nil, nil
 	}
 
-	//treeStore.IterParent(&parentIter, iter)
-	//	log.Printf("Элемент является дочерним: %v\n", hasParent)
-
-	if depth == 4 {
-		// Ищем людей в отделе
-		//		searchPeople(quotAdd("(&(o=" + rootName + ", " + parentName + ")(ou=" + itemName + "))"))
-		//		searchPeople("(ou=" + itemName + ")")
-		searchPeople("(&(o=" + rootName + ", " + parentName + ")(ou=" + itemName + "))")
-	} else if depth == 3 && !hasChildren {
-		// Ищем людей в отделе
-		//searchPeople(quotAdd("(&(o=" + parentName + ")(ou=" + itemName + "))"))
-		//searchPeople("(ou=" + itemName + ")")
-		searchPeople("(&(o=" + parentName + ")(ou=" + itemName + "))")
-	} else if depth == 3 && hasChildren {
-		// Ищем людей в отделе
-		searchPeople("(o=" + parentName + ", " + itemName + ")")
-		//searchPeople(quotAdd("(o=" + parentName + ", " + itemName + ")"))
+	// Первый уровень - источник
+	for _, root := range orgTrees {
+		if root.Name == names[0] {
+			return sourceOfRoot(root), findOrgNode(root, names[1:])
+		}
 	}
-
+	return nil, nil
 }
 
 func performSearch() {
@@ -1059,122 +1293,287 @@ func performSearch() {
 		return
 	}
 
-	// Формируем фильтр для поиска
-	filter := fmt.Sprintf("(|(cn=*%s*)(mail=*%s*)(telephoneNumber=*%s*))", text, text, text)
-
 	// Ищем людей
-	if searchPeople(filter) == 0 {
-		text = ConvertString(text)
-		if len(text) > 0 {
-			// Формируем фильтр для поиска
-			filter := fmt.Sprintf("(|(cn=*%s*)(mail=*%s*)(telephoneNumber=*%s*))", text, text, text)
-
-			// Ищем людей
-			searchPeople(filter)
-		}
+	entries, err := searchByText(text)
+	if err != nil {
+		glib.IdleAdd(func() {
+			showErrorDialog(err.Error())
+		})
+		return
 	}
+
+	glib.IdleAdd(func() {
+		showPeople(entries)
+	})
 }
 
-func searchPeople(filter string) int {
+// searchByText ищет людей по строке поиска (см. queryFilter). Если по обычному
+// тексту ничего не найдено, повторяет поиск в другой раскладке клавиатуры
+func searchByText(text string) ([]LDAPEntry, error) {
+	filter, plain, err := queryFilter(text)
+	if err != nil {
+		return nil, err
+	}
+	if !plain {
+		entries, err := fetchPeople(filter)
+		markMatches(entries, text)
+		return entries, err
+	}
 
-	if config.Debug {
-		fmt.Println(filter)
+	entries, err := searchPlainText(text)
+	if err != nil || len(entries) > 0 {
+		return entries, err
 	}
 
-	// Подключаемся к LDAP серверу
-	l, err := ldap.Dial("tcp", config.LDAPServer)
-	if err != nil {
-		glib.IdleAdd(func() {
-			showErrorDialog("Ошибка подключения к LDAP серверу: " + err.Error())
-		})
-		return -1
+	text = ConvertString(text)
+	if len(text) == 0 {
+		return entries, nil
 	}
-	defer l.Close()
+	return searchPlainText(text)
+}
 
-	// Аутентификация
-	err = l.Bind(config.BindDN, config.BindPassword)
-	if err != nil {
-		glib.IdleAdd(func() {
-			showErrorDialog("Ошибка аутентификации в LDAP: " + err.Error())
-		})
-		return -1
+// textFilter формирует фильтр поиска подстроки по атрибутам поиска.
+// Спецсимволы фильтра в тексте экранируются, "*" остается шаблоном
+func textFilter(text string) string {
+	return patternFilter(textTerm(text).pattern())
+}
+
+// patternFilter формирует фильтр поиска шаблона по атрибутам поиска
+func patternFilter(pattern string) string {
+	filter := "(|"
+	for _, name := range searchAttributes() {
+		filter += "(" + name + "=" + pattern + ")"
 	}
+	return filter + ")"
+}
 
-	// Поиск людей
-	searchRequest := ldap.NewSearchRequest(
-		config.BaseDN,
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		quotAdd("(&(objectClass=inetOrgPerson)"+filter+")"),
-		[]string{"cn", "mail", "telephoneNumber", "ou", "o", "title", "l", "postalAddress"},
-		nil,
-	)
+func searchPeople(src *source, node *OrgNode) int {
+
+	if config.Debug {
+		fmt.Println(node.Base, node.Filter)
+	}
 
-	sr, err := l.Search(searchRequest)
+	entries, err := fetchNodePeople(src, node)
 	if err != nil {
 		glib.IdleAdd(func() {
-			showErrorDialog("Ошибка поиска людей: " + err.Error())
+			showErrorDialog(err.Error())
 		})
 		return -1
 	}
 
+	// Сводка может потребовать запроса руководителя, поэтому формируется до обновления окна
+	summary := formatDepartmentSummary(node, entries)
+
 	// Обновляем результаты в основном потоке GTK
 	glib.IdleAdd(func() {
-		listStore, err := resultsView.GetModel()
+		showPeople(entries)
+		setDetailsText(summary)
+	})
+	return len(entries)
+}
+
+// fetchPeople выполняет поиск людей во всех источниках и возвращает отсортированный список
+func fetchPeople(filter string) ([]LDAPEntry, error) {
+	return fetchPeopleFrom(sources, filter)
+}
+
+// fetchPeopleFrom выполняет поиск людей в указанных источниках
+func fetchPeopleFrom(srcs []*source, filter string) ([]LDAPEntry, error) {
+	return fetchEntriesFrom(srcs, "", ldap.ScopeWholeSubtree, "(&"+entryFilter()+filter+")")
+}
+
+// errNotFound возвращается, если запись с заданным DN отсутствует
+var errNotFound = errors.New("Запись не найдена")
+
+// fetchPerson получает карточку человека по DN
+func fetchPerson(dn string) (LDAPEntry, error) {
+	entries, err := fetchEntriesFrom(sourcesForDN(dn), dn, ldap.ScopeBaseObject, entryFilter())
+	var ldapErr *ldap.Error
+	if errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultNoSuchObject {
+		return LDAPEntry{}, fmt.Errorf("%w: %s", errNotFound, dn)
+	}
+	if err != nil {
+		return LDAPEntry{}, err
+	}
+	if len(entries) == 0 {
+		return LDAPEntry{}, fmt.Errorf("%w: %s", errNotFound, dn)
+	}
+	return entries[0], nil
+}
+
+// fetchPhoto получает фотографию (jpegPhoto) записи по DN
+func fetchPhoto(dn string) ([]byte, error) {
+	var lastErr error
+	for _, s := range sourcesForDN(dn) {
+		entries, err := s.dir.Search(dn, ldap.ScopeBaseObject, "(objectClass=*)", []string{"jpegPhoto"})
 		if err != nil {
-			return
+			lastErr = fmt.Errorf("Ошибка получения фотографии: %v", err)
+			continue
 		}
+		if len(entries) > 0 {
+			return entries[0].GetRawAttributeValue("jpegPhoto"), nil
+		}
+	}
+	return nil, lastErr
+}
 
-		// Очищаем список
-		listStore.(*gtk.ListStore).Clear()
+// fetchEntries выполняет поиск в источнике с заданной базой и областью поиска.
+// Путь записи строится для группировки показанного дерева
+func (s *source) fetchEntries(baseDN string, scope int, filter string) ([]LDAPEntry, error) {
+	grouping := treeGrouping()
+	// Поиск людей
+	attributes := append([]string{"cn", "sn", "givenName", "initials", "mail", "telephoneNumber", "ou", "o", "title", "l", "postalAddress", "manager", "memberOf"}, s.treeAttributes(grouping)...)
+	attributes = append(attributes, typeAttributes()...)
+	attributes = append(attributes, searchAttributes()...)
+	attributes = append(attributes, s.structureAttributes()...)
+	entries, err := s.dir.Search(baseDN, scope, quotAdd(filter), attributes)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска людей: %w", err)
+	}
 
-		searchResult = nil
+	var result []LDAPEntry
+	for _, entry := range entries {
 
-		// Добавляем результаты
-		for _, entry := range sr.Entries {
-
-			var item LDAPEntry
-			item.CN = entry.GetAttributeValue("cn")
-			item.Mail = entry.GetAttributeValue("mail")
-			item.OU = quotRemove(entry.GetAttributeValue("ou"))
-			item.L = entry.GetAttributeValue("l")
-			item.Title = entry.GetAttributeValue("title")
-			//			item.O = quotRemove(entry.GetAttributeValue("o"))
-			item.O = entry.GetAttributeValue("o")
-			item.TelephoneNumber = entry.GetAttributeValue("telephoneNumber")
-			item.PostalAddress = quotRemove(entry.GetAttributeValue("postalAddress"))
-
-			searchResult = append(searchResult, item)
+		var item LDAPEntry
+		item.DN = entry.DN
+		item.CN = entry.GetAttributeValue("cn")
+		item.SN = entry.GetAttributeValue("sn")
+		item.GivenName = entry.GetAttributeValue("givenName")
+		item.Initials = entry.GetAttributeValue("initials")
+		item.Mail = entry.GetAttributeValue("mail")
+		item.OU = quotRemove(entry.GetAttributeValue("ou"))
+		item.L = entry.GetAttributeValue("l")
+		item.Title = entry.GetAttributeValue("title")
+		//			item.O = quotRemove(entry.GetAttributeValue("o"))
+		item.O = entry.GetAttributeValue("o")
+		item.TelephoneNumber = entry.GetAttributeValue("telephoneNumber")
+		item.PostalAddress = quotRemove(entry.GetAttributeValue("postalAddress"))
+		item.Manager = entry.GetAttributeValue("manager")
+		item.MemberOf = entry.GetAttributeValues("memberOf")
+		if t := classifyEntry(entry); t != nil {
+			item.Type = t.Name
+			item.Attributes = typedAttributes(entry)
 		}
+		keepAttributes(&item, entry, searchAttributes())
+		keepAttributes(&item, entry, s.structureAttributes())
+		item.Source = s.Name
+		var last treeLevel
+		for _, level := range s.treePath(entry, grouping) {
+			item.TreePath = append(item.TreePath, level.Name)
+			last = level
+		}
+		item.TreeKey = s.nodeKey(item.TreePath, last)
 
-		sort.Slice(searchResult, func(i, j int) (less bool) {
-			return searchResult[i].CN < searchResult[j].CN
-		})
+		result = append(result, item)
+	}
 
-		for _, entry := range searchResult {
-			iter := listStore.(*gtk.ListStore).Append()
-			listStore.(*gtk.ListStore).Set(iter,
-				[]int{0, 1, 2, 3, 4, 5},
-				[]any{
-					entry.CN,
-					entry.TelephoneNumber,
-					entry.Mail,
-					entry.Title,
-					entry.OU,
-					entry.O,
-				})
-		}
-		resultsView.ColumnsAutosize()
+	sort.Slice(result, func(i, j int) (less bool) {
+		return result[i].CN < result[j].CN
 	})
-	// Безопасное обновление текста
-	glib.IdleAdd(func() {
-		// Получаем границы текста
-		start, end := detailsBuffer.GetBounds()
 
-		// Удаляем старый текст
-		detailsBuffer.Delete(start, end)
-	})
-	return len(sr.Entries)
+	return result, nil
+}
+
+// showPeople выводит список людей в таблицу результатов.
+// Вызывается только из основного потока GTK
+func showPeople(entries []LDAPEntry) {
+	listStore, err := resultsView.GetModel()
+	if err != nil {
+		return
+	}
+
+	// Очищаем список
+	listStore.(*gtk.ListStore).Clear()
+
+	searchResult = entries
+
+	// Колонка совпавших полей нужна только для результатов поиска по строке
+	matched := false
+	for _, entry := range searchResult {
+		matched = matched || entry.Match != ""
+	}
+	matchColumn.SetVisible(matched)
+
+	for _, entry := range searchResult {
+		values, icon, title := resultValues(entry)
+		iter := listStore.(*gtk.ListStore).Append()
+		listStore.(*gtk.ListStore).Set(iter,
+			[]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+			[]any{
+				values[0],
+				values[1],
+				values[2],
+				values[3],
+				values[4],
+				values[5],
+				entry.Source,
+				icon,
+				title,
+				entry.Match,
+			})
+	}
+	resultsView.ColumnsAutosize()
+
+	// Получаем границы текста
+	start, end := detailsBuffer.GetBounds()
+
+	// Удаляем старый текст
+	detailsBuffer.Delete(start, end)
+	setAttributesDN("")
+}
+
+// setDetailsText заменяет текст в панели детальной информации.
+// Вызывается только из основного потока GTK
+func setDetailsText(details string) {
+	// Получаем границы текста
+	start, end := detailsBuffer.GetBounds()
+
+	// Удаляем старый текст
+	detailsBuffer.Delete(start, end)
+
+	// Вставляем новый текст
+	detailsBuffer.Insert(start, details)
+}
+
+// formatDetails формирует текст карточки сотрудника
+func formatDetails(entry LDAPEntry) string {
+	if t := entryTypeByName(entry.Type); t != nil {
+		details := t.card(entry)
+		if t.Card == "" {
+			details = fmt.Sprintf("%s: %s\nEmail: %s\nТелефон: %s\nОтдел: %s\nОрганизация: %s",
+				t.Title, entry.CN, entry.Mail, entry.TelephoneNumber, entry.OU, entry.O)
+		}
+		if entry.Source != "" {
+			details += "\nИсточник: " + entry.Source
+		}
+		return details
+	}
+
+	details := fmt.Sprintf("ФИО: %s\nEmail: %s\nТелефон: %s\nДолжность: %s\nОтдел: %s\nОрганизация: %s\nГород: %s\nАдрес: %s",
+		entry.CN, entry.Mail, entry.TelephoneNumber, entry.Title, entry.OU, entry.O, entry.L, entry.PostalAddress)
+	if entry.Manager != "" {
+		details += "\nРуководитель: " + dnName(entry.Manager)
+	}
+	if entry.Source != "" {
+		details += "\nИсточник: " + entry.Source
+	}
+	return details
+}
+
+// escapeFilter экранирует спецсимволы значения для LDAP фильтра (RFC 4515)
+func escapeFilter(value string) string {
+	var buffer bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '*', '(', ')', 0:
+			buffer.WriteString(fmt.Sprintf("\\%02x", c))
+		default:
+			buffer.WriteByte(c)
+		}
+	}
+	return buffer.String()
 }
+
 func clearSearch() {
 	// Безопасное обновление текста
 	glib.IdleAdd(func() {
@@ -1200,6 +1599,7 @@ func clearSearch() {
 
 		// Удаляем старый текст
 		detailsBuffer.Delete(start, end)
+		setAttributesDN("")
 
 		searchEntry.GrabFocusWithoutSelecting()
 
@@ -1228,54 +1628,40 @@ func onPersonSelected() {
 
 	// Получаем данные о человеке
 	fullName, _ := model.(*gtk.TreeModel).GetValue(iter, 0)
-	email, _ := model.(*gtk.TreeModel).GetValue(iter, 1)
-	phone, _ := model.(*gtk.TreeModel).GetValue(iter, 2)
-	//	title, _ := model.(*gtk.TreeModel).GetValue(iter, 3)
-	department, _ := model.(*gtk.TreeModel).GetValue(iter, 4)
-	organization, _ := model.(*gtk.TreeModel).GetValue(iter, 5)
 
 	fullNameStr, _ := fullName.GetString()
-	emailStr, _ := email.GetString()
-	phoneStr, _ := phone.GetString()
-	//	titleStr, _ := title.GetString()
-	deptStr, _ := department.GetString()
-	orgStr, _ := organization.GetString()
 
-	if fullNameStr != searchResult[index].CN {
+	if values, _, _ := resultValues(searchResult[index]); fullNameStr != values[0] {
 		fmt.Printf("Несоответсвие строки и индекса элемента : %d\n", index)
 		return
 	}
 
 	// Формируем детальную информацию
-	//details := fmt.Sprintf("ФИО: %s\nEmail: %s\nТелефон: %s\nОтдел: %s\nОрганизация: %s",
-	//	fullNameStr, emailStr, phoneStr, deptStr, orgStr)
-	details := fmt.Sprintf("ФИО: %s\nEmail: %s\nТелефон: %s\nДолжность: %s\nОтдел: %s\nОрганизация: %s\nГород: %s\nАдрес: %s",
-		fullNameStr, emailStr, phoneStr, searchResult[index].Title, deptStr, orgStr, searchResult[index].L, searchResult[index].PostalAddress)
+	details := formatDetails(searchResult[index])
 
 	// Безопасное обновление текста
+	dn := searchResult[index].DN
 	glib.IdleAdd(func() {
-		// Получаем границы текста
-		start, end := detailsBuffer.GetBounds()
-
-		// Удаляем старый текст
-		detailsBuffer.Delete(start, end)
-
-		// Вставляем новый текст
-		detailsBuffer.Insert(start, details)
+		setDetailsText(details)
+		setAttributesDN(dn)
 	})
 
-	pathTree := ""
-	strs := strings.Split(searchResult[index].O, ",")
-	pathTree = strs[0]
-	if len(strs) == 2 {
-		pathTree = pathTree + ":" + strs[1]
+	// Группы сотрудника добавляются в карточку после загрузки
+	go showPersonGroups(searchResult[index], details)
+
+	// Выделяем соответствующий отдел в дереве источника
+	entry := searchResult[index]
+	if !selectTreeKey(entry.TreeKey) && config.LazyTree {
+		// Отдел еще не загружен в дерево
+		go func() {
+			if revealInSource(entry.Source, entry.TreePath) {
+				glib.IdleAdd(func() {
+					selectTreeKey(entry.TreeKey)
+				})
+			}
+		}()
 	}
 
-	pathTree = pathTree + ":" + deptStr
-
-	// Выделяем соответствующий отдел в дереве
-	selectByPath(pathTree)
-
 }
 
 func showErrorDialog(message string) {
@@ -1291,40 +1677,77 @@ func showErrorDialog(message string) {
 }
 
 func isAlreadyRunning() bool {
-	// Проверяем, существует ли сокет
-	if _, err := os.Stat(config.SocketFile); err == nil {
-		// Пробуем подключиться к сокету
-		conn, err := net.Dial("unix", config.SocketFile)
+	// Уникальность экземпляра определяется владением именем на сессионной шине D-Bus
+	running, err := claimBusName()
+	if err == nil {
+		if !running {
+			// Сокет для скриптов остается, убираем оставшийся от прежнего процесса
+			if locked, _ := acquireInstanceLock(); locked {
+				os.Remove(config.SocketFile)
+			}
+		}
+		return running
+	}
+	if config.Debug {
+		fmt.Printf("Сессионная шина D-Bus недоступна: %v\n", err)
+	}
+
+	// Без D-Bus проверяем запуск через сокет.
+	// Блокировку удерживает запущенный экземпляр, поэтому удаление
+	// старого сокета не может помешать стартующей программе
+	locked, err := acquireInstanceLock()
+	if err != nil {
+		fmt.Printf("Ошибка блокировки %s: %v\n", lockFile(), err)
+
+		// Проверяем, существует ли сокет
+		if _, err := os.Stat(config.SocketFile); err == nil {
+			// Пробуем подключиться к сокету
+			conn, err := dialInstance()
+			if err == nil {
+				conn.Close()
+				return true
+			}
+		}
+		return false
+	}
+
+	if locked {
+		// Другого экземпляра нет, сокет остался от завершившегося процесса
+		os.Remove(config.SocketFile)
+		return false
+	}
+
+	// Ждем, пока запускающийся экземпляр создаст сокет
+	for i := 0; i < 20; i++ {
+		conn, err := dialInstance()
 		if err == nil {
 			conn.Close()
-			return true
+			break
 		}
-		// Если подключиться не удалось, удаляем старый сокет
-		os.Remove(config.SocketFile)
+		time.Sleep(100 * time.Millisecond)
 	}
-	return false
+	return true
 }
 
 func activateExistingInstance() {
-	conn, err := net.Dial("unix", config.SocketFile)
-	if err != nil {
-		return
+	if sessionBus != nil {
+		if err := activateViaBus(); err == nil {
+			return
+		}
 	}
-	defer conn.Close()
+	sendInstanceCommand(controlRequest{Command: "activate"})
+}
 
-	_, err = conn.Write([]byte("activate\n"))
-	if err != nil {
+func startUnixSocketServer() {
+	// Создаем директорию для сокета, если ее нет
+	if err := prepareSocketDir(); err != nil {
+		fmt.Printf("Ошибка создания каталога сокета: %v\n", err)
 		return
 	}
-}
 
-func startUnixSocketServer() {
 	// Удаляем старый сокет, если он существует
 	os.Remove(config.SocketFile)
 
-	// Создаем директорию для сокета, если ее нет
-	os.MkdirAll(filepath.Dir(config.SocketFile), 0755)
-
 	// Создаем Unix socket
 	l, err := net.Listen("unix", config.SocketFile)
 	if err != nil {
@@ -1346,21 +1769,6 @@ func startUnixSocketServer() {
 	}
 }
 
-func handleConnection(conn net.Conn) {
-	defer conn.Close()
-
-	buf := make([]byte, 1024)
-	n, err := conn.Read(buf)
-	if err != nil {
-		return
-	}
-
-	cmd := string(buf[:n])
-	if cmd == "activate\n" {
-		restoreFromTray()
-	}
-}
-
 func setWindowIcon() {
 
 	// Декодируем иконку
@@ -1374,7 +1782,10 @@ func setWindowIcon() {
 
 }
 
-func selectByPath(pathStr string) {
+// selectByPath выделяет узел дерева по пути вида "Организация:Отдел".
+// Если источников несколько, путь может начинаться с имени источника.
+// Возвращает false, если путь найден не полностью
+func selectByPath(pathStr string) bool {
 
 	if config.Debug {
 		fmt.Println(pathStr)
@@ -1385,41 +1796,55 @@ func selectByPath(pathStr string) {
 		parts[i] = strings.TrimSpace(parts[i])
 		if parts[i] == "" {
 			log.Println("Некорректный путь")
-			return
+			return false
 		}
 	}
-	model, err := treeView.GetModel()
-	if err != nil {
-		return
+	if len(orgTrees) == 0 {
+		return false
 	}
 
-	var store *gtk.TreeStore
+	// Путь может начинаться с имени источника
+	for _, root := range orgTrees {
+		if root.Name == parts[0] && len(parts) > 1 {
+			if node := findOrgNode(root, parts[1:]); node != nil {
+				return selectTreeKey(node.Key)
+			}
+		}
+	}
 
-	store, ok := model.(*gtk.TreeStore)
-	if !ok {
-		fmt.Println("модель не является TreeStore")
-		return
+	// Ищем путь под корнем каждого источника
+	for _, root := range orgTrees {
+		if node := findOrgNode(root, parts); node != nil {
+			return selectTreeKey(node.Key)
+		}
 	}
 
-	currentPath := "0"
-	s := "0"
-	// Перебираем каждую часть пути
+	// Выделяем найденную часть пути в первом источнике
+	node := orgTrees[0]
 	for _, part := range parts {
-		// Формируем путь для текущего уровня
-		currentPath = s + ":0"
-
-		n, ok := findStrOnLevel(store, part, currentPath)
-		if ok {
-			s += ":" + fmt.Sprintf("%d", n)
-
+		child, ok := node.Children[part]
+		if !ok {
+			break
 		}
+		node = child
+	}
+	selectTreeKey(node.Key)
+	return false
+}
 
+// selectTreeKey разворачивает дерево до узла с заданным ключом и выделяет его
+func selectTreeKey(key string) bool {
+	store := treeStore()
+	if store == nil || key == "" {
+		return false
 	}
-	//	fmt.Println(s)
-	//строим путь
-	path, err := gtk.TreePathNewFromString(s)
+	iter, ok := findTreeKey(store, nil, key)
+	if !ok {
+		return false
+	}
+	path, err := store.GetPath(iter)
 	if err != nil {
-		return
+		return false
 	}
 	//разворачиваем до элемент
 	treeView.ExpandToPath(path)
@@ -1429,6 +1854,21 @@ func selectByPath(pathStr string) {
 
 	//прокручиваем до элемента
 	treeView.ScrollToCell(path, nil, true, 0.5, 0.5)
+	return true
+}
+
+// findTreeKey ищет строку дерева с заданным ключом среди вложенных строк parent
+func findTreeKey(store *gtk.TreeStore, parent *gtk.TreeIter, key string) (*gtk.TreeIter, bool) {
+	var iter gtk.TreeIter
+	for ok := store.IterChildren(parent, &iter); ok; ok = store.IterNext(&iter) {
+		if getKeyIter(store, &iter) == key {
+			return &iter, true
+		}
+		if found, ok := findTreeKey(store, &iter, key); ok {
+			return found, true
+		}
+	}
+	return nil, false
 }
 
 func getTextIter(store *gtk.TreeStore, iter *gtk.TreeIter) (string, error) {
@@ -1440,21 +1880,14 @@ func getTextIter(store *gtk.TreeStore, iter *gtk.TreeIter) (string, error) {
 	return str, err
 }
 
-func findStrOnLevel(store *gtk.TreeStore, str string, path string) (int, bool) {
-	iter, err := store.GetIterFromString(path)
+// getKeyIter возвращает ключ узла из скрытой колонки модели дерева
+func getKeyIter(store *gtk.TreeStore, iter *gtk.TreeIter) string {
+	val, err := store.GetValue(iter, 2)
 	if err != nil {
-		return -1, false
+		return ""
 	}
-	ok := true
-	for index := 0; ok; index++ {
-		s, _ := getTextIter(store, iter)
-		//		fmt.Printf(s)
-		if s == str {
-			return index, true
-		}
-		ok = store.IterNext(iter)
-	}
-	return -1, false
+	str, _ := val.GetString()
+	return str
 }
 
 var ConvertMap = map[rune]rune{
@@ -1531,6 +1964,9 @@ func ConvertString(in string) string {
 		r, ok := ConvertMap[ch]
 		if ok {
 			buffer.WriteString(string(r))
+		} else if ch == ' ' {
+			// Пробелы сохраняются, чтобы ФИО делилось на слова
+			buffer.WriteRune(ch)
 		}
 	}
 	str := buffer.String()
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// nameTokens разбивает ФИО на слова в нижнем регистре. Ё заменяется на Е,
+// двойные фамилии через дефис и инициалы с точками делятся на отдельные слова
+func nameTokens(text string) []string {
+	text = strings.ReplaceAll(strings.ToLower(text), "ё", "е")
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '.' || r == '-' || r == ','
+	})
+}
+
+// nameQuery проверяет, что строку поиска стоит искать как ФИО: она состоит
+// только из букв, пробелов, точек и дефисов и содержит несколько слов или Е/Ё
+func nameQuery(text string, tokens []string) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	for _, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsSpace(r) && r != '.' && r != '-' {
+			return false
+		}
+	}
+	return len(tokens) > 1 || strings.ContainsAny(strings.ToLower(text), "её")
+}
+
+// nameFilter формирует LDAP фильтр, отбирающий записи, в ФИО которых есть все
+// слова строки поиска в любом порядке. Слова с Е ищутся и в вариантах с Ё,
+// поэтому фильтр отбирает с запасом, а точная проверка выполняется в matchName
+func nameFilter(tokens []string) string {
+	filter := "(&"
+	for _, token := range tokens {
+		variants := yoVariants(token)
+		filter += "(|"
+		for _, variant := range variants {
+			filter += "(cn=*" + escapeFilter(variant) + "*)"
+		}
+		for _, variant := range variants {
+			filter += "(givenName=" + escapeFilter(variant) + "*)"
+		}
+		if len([]rune(token)) == 1 {
+			// Инициал может быть только в атрибуте initials
+			for _, variant := range variants {
+				filter += "(initials=*" + escapeFilter(variant) + "*)"
+			}
+		}
+		filter += ")"
+	}
+	return filter + ")"
+}
+
+// yoVariants возвращает слово и его варианты с Ё на месте каждой Е.
+// Ё в слове бывает только одна, поэтому вариантов на один больше, чем букв Е
+func yoVariants(token string) []string {
+	variants := []string{token}
+	runes := []rune(token)
+	for i, r := range runes {
+		if r == 'е' {
+			variant := slices.Clone(runes)
+			variant[i] = 'ё'
+			variants = append(variants, string(variant))
+		}
+	}
+	return variants
+}
+
+// wildcard убирает из шаблона подряд идущие "*"
+func wildcard(pattern string) string {
+	for strings.Contains(pattern, "**") {
+		pattern = strings.ReplaceAll(pattern, "**", "*")
+	}
+	return pattern
+}
+
+// matchName проверяет, что каждое слово строки поиска - начало своего слова
+// ФИО записи. Однобуквенные слова считаются инициалами, поэтому сравниваются
+// с первой буквой слов ФИО, имени (givenName) и атрибута initials
+func matchName(entry LDAPEntry, tokens []string) bool {
+	var words []string
+	seen := map[string]bool{}
+	for _, text := range []string{entry.CN, entry.SN, entry.GivenName} {
+		for _, word := range nameTokens(text) {
+			if !seen[word] {
+				seen[word] = true
+				words = append(words, word)
+			}
+		}
+	}
+	// Инициал нужен, только если слова на эту букву нет, например
+	// отчества в ФИО "Иванов Иван" с initials "И.П."
+	for _, initial := range nameTokens(entry.Initials) {
+		if !slices.ContainsFunc(words, func(word string) bool { return strings.HasPrefix(word, initial) }) {
+			words = append(words, initial)
+		}
+	}
+
+	// Длинные слова подбираются первыми, чтобы инициал не занял слово фамилии
+	tokens = append([]string{}, tokens...)
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return len([]rune(tokens[i])) > len([]rune(tokens[j]))
+	})
+	used := make([]bool, len(words))
+	for _, token := range tokens {
+		found := false
+		for i, word := range words {
+			if !used[i] && strings.HasPrefix(word, token) {
+				used[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// searchPlainText ищет записи по обычному тексту. Текст, похожий на ФИО,
+// ищется и как подстрока, и по словам ФИО в любом порядке. Записи, найденные
+// как подстрока, показываются все, а найденные по словам ФИО - только
+// после проверки matchName
+func searchPlainText(text string) ([]LDAPEntry, error) {
+	entries, err := fetchPeople(textFilter(text))
+	markMatches(entries, text)
+	tokens := nameTokens(text)
+	if err != nil || !nameQuery(text, tokens) {
+		return entries, err
+	}
+
+	found := map[string]bool{}
+	for i, entry := range entries {
+		found[normalizeDN(entry.DN)] = true
+		if matchName(entry, tokens) && !strings.Contains(entry.Match, fieldTitle("cn")) {
+			entries[i].Match = strings.TrimPrefix(entry.Match+", "+fieldTitle("cn"), ", ")
+		}
+	}
+
+	// Если поиск по ФИО не удался, например из-за ограничения числа
+	// записей на сервере, показываются найденные как подстрока
+	byName, err := fetchPeople(nameFilter(tokens))
+	if err != nil {
+		log.Printf("Ошибка поиска по ФИО: %v\n", err)
+		return entries, nil
+	}
+	for _, entry := range byName {
+		if found[normalizeDN(entry.DN)] || !matchName(entry, tokens) {
+			continue
+		}
+		entry.Match = fieldTitle("cn")
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CN < entries[j].CN
+	})
+	return entries, nil
+}
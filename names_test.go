@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/ldap.v2"
+)
+
+func TestNameTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Иванов Иван", []string{"иванов", "иван"}},
+		{"  СЕМЁНОВ  пётр ", []string{"семенов", "петр"}},
+		{"Иванов И.П.", []string{"иванов", "и", "п"}},
+		{"Петров-Водкин, Кузьма", []string{"петров", "водкин", "кузьма"}},
+		{"", []string{}},
+	}
+	for _, tt := range tests {
+		if got := nameTokens(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("nameTokens(%q) = %q, ожидалось %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestNameQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"Иванов Иван", true},
+		{"Семенов", true},
+		{"Семёнов", true},
+		{"Иванов", false},
+		{"Иванов И.П.", true},
+		{"ivanov@example.com", false},
+		{"123 45", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := nameQuery(tt.text, nameTokens(tt.text)); got != tt.want {
+			t.Errorf("nameQuery(%q) = %v, ожидалось %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestNameFilter(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"иванов иван", "(&(|(cn=*иванов*)(givenName=иванов*))(|(cn=*иван*)(givenName=иван*)))"},
+		{"семенов", "(&(|(cn=*семенов*)(cn=*сёменов*)(cn=*семёнов*)(givenName=семенов*)(givenName=сёменов*)(givenName=семёнов*)))"},
+		{"Пётр", "(&(|(cn=*петр*)(cn=*пётр*)(givenName=петр*)(givenName=пётр*)))"},
+		{"иванов и", "(&(|(cn=*иванов*)(givenName=иванов*))(|(cn=*и*)(givenName=и*)(initials=*и*)))"},
+		{"ё", "(&(|(cn=*е*)(cn=*ё*)(givenName=е*)(givenName=ё*)(initials=*е*)(initials=*ё*)))"},
+		{"о'нил (мл)", `(&(|(cn=*о'нил*)(givenName=о'нил*))(|(cn=*\28мл\29*)(givenName=\28мл\29*)))`},
+	}
+	for _, tt := range tests {
+		got := nameFilter(nameTokens(tt.text))
+		if got != tt.want {
+			t.Errorf("nameFilter(%q) = %q, ожидалось %q", tt.text, got, tt.want)
+		}
+		if _, err := ldap.CompileFilter(got); err != nil {
+			t.Errorf("nameFilter(%q) = %q: неверный фильтр: %v", tt.text, got, err)
+		}
+	}
+}
+
+func TestMatchName(t *testing.T) {
+	ivanov := LDAPEntry{CN: "Иванов Иван", SN: "Иванов", GivenName: "Иван", Initials: "И.П."}
+	semenov := LDAPEntry{CN: "Семёнов Пётр Алексеевич"}
+	vodkin := LDAPEntry{CN: "Петров-Водкин Кузьма Сергеевич"}
+	tests := []struct {
+		entry LDAPEntry
+		text  string
+		want  bool
+	}{
+		{ivanov, "иван иванов", true},
+		{ivanov, "Иванов Иван", true},
+		{ivanov, "иванов и п", true},
+		{ivanov, "и.п. иванов", true},
+		{ivanov, "иванов и и", false},
+		{ivanov, "иванов п п", false},
+		{ivanov, "иванова", false},
+		{ivanov, "иванов петр", false},
+		{semenov, "семенов петр", true},
+		{semenov, "СЕМЁНОВ П.А.", true},
+		{semenov, "петр алексеевич", true},
+		{semenov, "семенова", false},
+		{vodkin, "водкин кузьма", true},
+		{vodkin, "петров-водкин", true},
+		{vodkin, "кузьма п в с", true},
+		{vodkin, "водкин петр", true},
+		{vodkin, "водкин иван", false},
+	}
+	for _, tt := range tests {
+		if got := matchName(tt.entry, nameTokens(tt.text)); got != tt.want {
+			t.Errorf("matchName(%q, %q) = %v, ожидалось %v", tt.entry.CN, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestWildcard(t *testing.T) {
+	tests := map[string]string{
+		"**":      "*",
+		"***":     "*",
+		"*a**b*":  "*a*b*",
+		"a":       "a",
+		`*\2a**`:  `*\2a*`,
+		"":        "",
+		"*ab*c**": "*ab*c*",
+	}
+	for pattern, want := range tests {
+		if got := wildcard(pattern); got != want {
+			t.Errorf("wildcard(%q) = %q, ожидалось %q", pattern, got, want)
+		}
+	}
+}
+
+func TestSearchPlainText(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "people.ldif")
+	data := `dn: cn=Семёнов Пётр,dc=example
+objectClass: inetOrgPerson
+cn: Семёнов Пётр
+givenName: Пётр
+
+dn: cn=Петров Иван,dc=example
+objectClass: inetOrgPerson
+cn: Петров Иван
+title: Инженер
+title: Семенов и партнеры
+
+dn: cn=Иванов Семен,dc=example
+objectClass: inetOrgPerson
+cn: Иванов Семен
+`
+	if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := newDirectory(SourceConfig{Backend: backendLDIF, DataFile: file, BaseDN: "dc=example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := sources
+	sources = []*source{{BaseDN: "dc=example", dir: dir}}
+	defer func() { sources = saved }()
+
+	tests := []struct {
+		text string
+		want map[string]string
+	}{
+		// Второе значение title совпадает только на сервере, но запись не теряется
+		{"семен", map[string]string{"Иванов Семен": "ФИО", "Петров Иван": "", "Семёнов Пётр": "ФИО"}},
+		{"петр семенов", map[string]string{"Семёнов Пётр": "ФИО"}},
+		{"ё", map[string]string{"Семёнов Пётр": "ФИО"}},
+	}
+	for _, tt := range tests {
+		entries, err := searchPlainText(tt.text)
+		if err != nil {
+			t.Fatalf("searchPlainText(%q): %v", tt.text, err)
+		}
+		got := map[string]string{}
+		for _, entry := range entries {
+			got[entry.CN] = entry.Match
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("searchPlainText(%q) = %v, ожидалось %v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"gopkg.in/ldap.v2"
+)
+
+// Предел длины цепочки руководителей на случай циклических ссылок в manager
+const maxManagerChain = 50
+
+// fetchManagerChain возвращает руководителей сотрудника
+// от непосредственного до самого верхнего
+func fetchManagerChain(entry LDAPEntry) ([]LDAPEntry, error) {
+	var chain []LDAPEntry
+	seen := map[string]bool{strings.ToLower(entry.DN): true}
+	for dn := entry.Manager; dn != "" && len(chain) < maxManagerChain; {
+		if seen[strings.ToLower(dn)] {
+			break
+		}
+		seen[strings.ToLower(dn)] = true
+
+		manager, err := fetchPerson(dn)
+		if errors.Is(err, errNotFound) {
+			// Руководитель может отсутствовать в справочнике, тогда известен только DN
+			chain = append(chain, LDAPEntry{DN: dn, CN: dnName(dn)})
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, manager)
+		dn = manager.Manager
+	}
+	return chain, nil
+}
+
+// fetchDirectReports возвращает непосредственных подчиненных сотрудника
+func fetchDirectReports(entry LDAPEntry) ([]LDAPEntry, error) {
+	return fetchPeopleFrom(sourcesForDN(entry.DN), "(manager="+escapeFilter(entry.DN)+")")
+}
+
+// dnName возвращает значение первого RDN, например "Иванов" для "cn=Иванов,ou=..."
+func dnName(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
+
+// formatOrgChartDOT формирует схему подчиненности сотрудников в формате Graphviz DOT.
+// Руководители, не входящие в список, показываются пунктиром
+func formatOrgChartDOT(title string, entries []LDAPEntry) string {
+	ids := map[string]string{}
+	for i, entry := range entries {
+		ids[strings.ToLower(entry.DN)] = fmt.Sprintf("n%d", i)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph orgchart {\n")
+	fmt.Fprintf(&b, "\tlabel=%s;\n\tlabelloc=t;\n\trankdir=TB;\n", dotQuote(title))
+	fmt.Fprintf(&b, "\tnode [shape=box, style=rounded, fontname=\"sans-serif\"];\n")
+	for i, entry := range entries {
+		label := entry.CN
+		if entry.Title != "" {
+			label += "\n" + entry.Title
+		}
+		fmt.Fprintf(&b, "\tn%d [label=%s];\n", i, dotQuote(label))
+	}
+
+	external := 0
+	for i, entry := range entries {
+		if entry.Manager == "" {
+			continue
+		}
+		id, ok := ids[strings.ToLower(entry.Manager)]
+		if !ok {
+			id = fmt.Sprintf("m%d", external)
+			external++
+			ids[strings.ToLower(entry.Manager)] = id
+			fmt.Fprintf(&b, "\t%s [label=%s, style=\"rounded,dashed\"];\n", id, dotQuote(dnName(entry.Manager)))
+		}
+		fmt.Fprintf(&b, "\t%s -> n%d;\n", id, i)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotQuote заключает строку в кавычки по правилам DOT
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+// renderSVG преобразует схему DOT в SVG программой dot из пакета Graphviz
+func renderSVG(dot string) ([]byte, error) {
+	path, err := exec.LookPath("dot")
+	if err != nil {
+		return nil, fmt.Errorf("Для экспорта в SVG нужна программа dot из пакета Graphviz")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, "-Tsvg")
+	cmd.Stdin = strings.NewReader(dot)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка программы dot: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// orgChartData формирует схему отдела в формате DOT или SVG
+func orgChartData(title string, entries []LDAPEntry, svg bool) ([]byte, error) {
+	dot := formatOrgChartDOT(title, entries)
+	if svg {
+		return renderSVG(dot)
+	}
+	return []byte(dot), nil
+}
+
+// chartFileName заменяет в названии отдела символы, недопустимые в имени файла
+func chartFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == 0 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return "orgchart"
+	}
+	return name
+}
+
+// exportDepartmentChart сохраняет схему подчиненности выбранного в дереве отдела.
+// Вызывается только из основного потока GTK
+func exportDepartmentChart() {
+	src, node := selectedTreeNode()
+	if node == nil || !node.searchable() {
+		showErrorDialog("Выберите отдел в дереве")
+		return
+	}
+
+	dialog, err := gtk.FileChooserDialogNewWith2Buttons("Экспорт схемы подчиненности", mainWindow, gtk.FILE_CHOOSER_ACTION_SAVE,
+		"Отмена", gtk.RESPONSE_CANCEL, "Сохранить", gtk.RESPONSE_ACCEPT)
+	if err != nil {
+		fmt.Printf("Ошибка создания диалога: %v\n", err)
+		return
+	}
+	dialog.SetDoOverwriteConfirmation(true)
+
+	// SVG предлагается, только если установлен Graphviz
+	formats := []struct{ name, pattern string }{{"Graphviz DOT", "*.dot"}}
+	ext := ".dot"
+	if _, err := exec.LookPath("dot"); err == nil {
+		formats = append([]struct{ name, pattern string }{{"SVG", "*.svg"}}, formats...)
+		ext = ".svg"
+	}
+	dialog.SetCurrentName(chartFileName(node.Name) + ext)
+	for _, f := range formats {
+		filter, err := gtk.FileFilterNew()
+		if err != nil {
+			continue
+		}
+		filter.SetName(f.name)
+		filter.AddPattern(f.pattern)
+		dialog.AddFilter(filter)
+	}
+
+	response := dialog.Run()
+	filename := dialog.GetFilename()
+	dialog.Destroy()
+	if response != gtk.RESPONSE_ACCEPT || filename == "" {
+		return
+	}
+
+	// Без Graphviz файл сохраняется в DOT, даже если расширение другое
+	svg := ext == ".svg" && !strings.HasSuffix(strings.ToLower(filename), ".dot")
+	go func() {
+		entries, err := fetchNodePeople(src, node)
+		if err == nil {
+			var data []byte
+			data, err = orgChartData(node.Name, entries, svg)
+			if err == nil {
+				err = os.WriteFile(filename, data, 0644)
+			}
+		}
+		if err != nil {
+			glib.IdleAdd(func() {
+				showErrorDialog(err.Error())
+			})
+		}
+	}()
+}
+
+// orgChartView окно подчиненности: цепочка руководителей выбранного
+// сотрудника и его подчиненные. По щелчку на человеке окно показывает его
+type orgChartView struct {
+	window  *gtk.Window
+	holder  *gtk.Box
+	content *gtk.Box
+	// DN сотрудника, которого показывает окно
+	dn string
+}
+
+// showOrgChart открывает окно подчиненности сотрудника
+func showOrgChart(entry LDAPEntry) {
+	window, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	if err != nil {
+		fmt.Printf("Ошибка создания окна: %v\n", err)
+		return
+	}
+	window.SetTransientFor(mainWindow)
+	window.SetDefaultSize(500, 600)
+
+	scrolled, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		fmt.Printf("Ошибка создания прокручиваемой области: %v\n", err)
+		return
+	}
+	holder, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
+	if err != nil {
+		fmt.Printf("Ошибка создания контейнера: %v\n", err)
+		return
+	}
+	scrolled.Add(holder)
+	window.Add(scrolled)
+
+	view := &orgChartView{window: window, holder: holder}
+	view.show(entry)
+	window.ShowAll()
+}
+
+// show загружает руководителей и подчиненных сотрудника и показывает их в окне
+func (v *orgChartView) show(entry LDAPEntry) {
+	v.window.SetTitle("Подчиненность: " + entry.CN)
+	v.dn = entry.DN
+	go func() {
+		chain, err := fetchManagerChain(entry)
+		var reports []LDAPEntry
+		if err == nil {
+			reports, err = fetchDirectReports(entry)
+		}
+		glib.IdleAdd(func() {
+			// Пока шел поиск, мог быть выбран другой сотрудник
+			if v.dn != entry.DN {
+				return
+			}
+			if err != nil {
+				showErrorDialog(err.Error())
+				return
+			}
+			v.render(entry, chain, reports)
+		})
+	}()
+}
+
+// render заполняет окно: руководители сверху вниз, сотрудник и его подчиненные.
+// Вызывается только из основного потока GTK
+func (v *orgChartView) render(entry LDAPEntry, chain, reports []LDAPEntry) {
+	if v.content != nil {
+		v.holder.Remove(v.content)
+	}
+	content, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 2)
+	if err != nil {
+		fmt.Printf("Ошибка создания контейнера: %v\n", err)
+		return
+	}
+	content.SetMarginTop(10)
+	content.SetMarginStart(10)
+
+	level := 0
+	for i := len(chain) - 1; i >= 0; i-- {
+		v.addPerson(content, chain[i], level)
+		level++
+	}
+
+	if label, err := gtk.LabelNew(""); err == nil {
+		text := "<b>" + html.EscapeString(entry.CN) + "</b>"
+		if entry.Title != "" {
+			text += " — " + html.EscapeString(entry.Title)
+		}
+		label.SetMarkup(text)
+		label.SetHAlign(gtk.ALIGN_START)
+		label.SetMarginStart(level*20 + 6)
+		content.PackStart(label, false, false, 4)
+	}
+
+	if button, err := gtk.ButtonNewWithLabel("Показать в справочнике"); err == nil {
+		button.SetHAlign(gtk.ALIGN_START)
+		button.SetMarginStart(level * 20)
+		button.Connect("clicked", func() {
+			showPeople([]LDAPEntry{entry})
+			setDetailsText(formatDetails(entry))
+			setAttributesDN(entry.DN)
+		})
+		content.PackStart(button, false, false, 0)
+	}
+
+	if label, err := gtk.LabelNew(fmt.Sprintf("Подчиненные: %d", len(reports))); err == nil {
+		label.SetHAlign(gtk.ALIGN_START)
+		label.SetMarginStart((level+1)*20 + 6)
+		label.SetMarginTop(6)
+		content.PackStart(label, false, false, 0)
+	}
+	for _, report := range reports {
+		v.addPerson(content, report, level+1)
+	}
+
+	v.holder.PackStart(content, false, false, 0)
+	v.content = content
+	content.ShowAll()
+}
+
+// addPerson добавляет в окно кнопку перехода к сотруднику
+func (v *orgChartView) addPerson(box *gtk.Box, entry LDAPEntry, level int) {
+	label := entry.CN
+	if entry.Title != "" {
+		label += " — " + entry.Title
+	}
+	button, err := gtk.ButtonNewWithLabel(label)
+	if err != nil {
+		return
+	}
+	button.SetRelief(gtk.RELIEF_NONE)
+	button.SetHAlign(gtk.ALIGN_START)
+	button.SetMarginStart(level * 20)
+	button.Connect("clicked", func() {
+		v.show(entry)
+	})
+	box.PackStart(button, false, false, 0)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/ldap.v2"
+)
+
+// Способы группировки записей в дереве
+const (
+	groupingOrg      = "org"
+	groupingLocation = "location"
+	groupingTitle    = "title"
+	groupingAddress  = "address"
+)
+
+// groupings способы группировки в порядке показа в списке над деревом
+var groupings = []struct {
+	ID    string
+	Title string
+}{
+	{groupingOrg, "Организации и отделы"},
+	{groupingLocation, "Города и организации"},
+	{groupingTitle, "Должности"},
+	{groupingAddress, "Адреса и здания"},
+}
+
+// Текущая группировка дерева, меняется списком над деревом.
+// Используется только из основного потока GTK, фоновые загрузки
+// получают группировку параметром
+var currentGrouping = groupingOrg
+
+// Группировка показанного дерева. Читается при поиске людей для пути
+// записи в дереве, поэтому хранится атомарно
+var shownGrouping atomic.Value
+
+// Заголовок узла для записей с незаполненным атрибутом группировки
+const noValueTitle = "(не указано)"
+
+// currentGroupingTitle возвращает название текущей группировки
+func currentGroupingTitle() string {
+	return groupingName(currentGrouping)
+}
+
+// groupingName возвращает название группировки
+func groupingName(grouping string) string {
+	for _, g := range groupings {
+		if g.ID == grouping {
+			return g.Title
+		}
+	}
+	return defaultTreeTitle
+}
+
+// treeGrouping возвращает группировку показанного дерева
+func treeGrouping() string {
+	if grouping, ok := shownGrouping.Load().(string); ok {
+		return grouping
+	}
+	return groupingOrg
+}
+
+// treeLevel уровень пути записи в дереве организаций
+type treeLevel struct {
+	Name string
+	// Фильтр людей узла вместе с вложенными узлами
+	Filter string
+	// База поиска людей узла, если узел соответствует записи каталога
+	Base string
+}
+
+// searchable проверяет, можно ли показать людей узла
+func (n *OrgNode) searchable() bool {
+	return n.Filter != "" || n.Base != ""
+}
+
+// buildOrgTree строит дерево источника произвольной глубины
+// по путям записей в дереве при группировке grouping.
+// Численность узлов считается только по сотрудникам
+func (s *source) buildOrgTree(entries []*ldap.Entry, grouping string) *OrgNode {
+	root := &OrgNode{
+		Name:     s.title(grouping),
+		Children: make(map[string]*OrgNode),
+		Key:      s.nodeKey(nil, treeLevel{}),
+	}
+
+	for _, entry := range entries {
+		node := root
+		var names []string
+		for _, level := range s.treePath(entry, grouping) {
+			names = append(names, level.Name)
+			child, exists := node.Children[level.Name]
+			if !exists {
+				child = &OrgNode{
+					Name:     level.Name,
+					Children: make(map[string]*OrgNode),
+					Key:      s.nodeKey(names, level),
+					Filter:   level.Filter,
+					Base:     level.Base,
+				}
+				node.Children[level.Name] = child
+			}
+			node = child
+		}
+		// Записи других типов показываются в узле, но не входят в численность
+		if classifyEntry(entry) == nil {
+			node.Direct++
+		}
+	}
+
+	countTotals(root)
+	return root
+}
+
+// nodeKey формирует ключ узла дерева источника по пути от корня.
+// Узлы дерева по DN различаются по DN, остальные - по полному пути
+func (s *source) nodeKey(names []string, level treeLevel) string {
+	if level.Base != "" && level.Filter == "" {
+		dn := level.Base
+		if parsed, err := ldap.ParseDN(dn); err == nil {
+			dn = formatDN(parsed.RDNs)
+		}
+		return "dn:" + normalizeDN(dn)
+	}
+	return "path:" + strings.Join(append([]string{s.Name}, names...), "\x00")
+}
+
+// treeAttributes возвращает атрибуты, нужные для построения пути записи в дереве
+func (s *source) treeAttributes(grouping string) []string {
+	switch grouping {
+	case groupingLocation:
+		return append([]string{"l"}, s.structureAttributes()...)
+	case groupingTitle:
+		return []string{"title"}
+	case groupingAddress:
+		return []string{"postalAddress"}
+	}
+	return s.structureAttributes()
+}
+
+// structureAttributes возвращает атрибуты оргструктуры источника
+func (s *source) structureAttributes() []string {
+	switch {
+	case s.treeFromDN:
+		return nil
+	case len(s.treePathAttributes) > 0:
+		return s.treePathAttributes
+	}
+	return []string{"o", "ou"}
+}
+
+// treePath возвращает путь записи в дереве при группировке grouping
+func (s *source) treePath(entry *ldap.Entry, grouping string) []treeLevel {
+	switch grouping {
+	case groupingLocation:
+		return groupedTreePath(entry, "l", s.structurePath(entry))
+	case groupingTitle:
+		return groupedTreePath(entry, "title", nil)
+	case groupingAddress:
+		return groupedTreePath(entry, "postalAddress", nil)
+	}
+	return s.structurePath(entry)
+}
+
+// groupedTreePath добавляет к пути записи верхний уровень по значению атрибута.
+// Фильтры вложенных уровней ограничиваются этим значением
+func groupedTreePath(entry *ldap.Entry, attr string, inner []treeLevel) []treeLevel {
+	value := strings.TrimSpace(quotRemove(entry.GetAttributeValue(attr)))
+	top := treeLevel{Name: value, Filter: "(" + attr + "=" + escapeFilter(value) + ")"}
+	if value == "" {
+		top = treeLevel{Name: noValueTitle, Filter: "(!(" + attr + "=*))"}
+	}
+
+	path := []treeLevel{top}
+	for _, level := range inner {
+		if level.Filter == "" && level.Base == "" {
+			// Уровень без списка людей, например организация целиком
+			path = append(path, level)
+			continue
+		}
+		path = append(path, treeLevel{Name: level.Name, Filter: "(&" + top.Filter + level.Filter + ")", Base: level.Base})
+	}
+	return path
+}
+
+// structurePath возвращает путь записи в оргструктуре от организации до отдела.
+// Иерархия строится по DN записи, по списку атрибутов из конфига
+// или, по умолчанию, по атрибутам "o" вида "Организация, Подразделение" и "ou"
+func (s *source) structurePath(entry *ldap.Entry) []treeLevel {
+	switch {
+	case s.treeFromDN:
+		return s.dnTreePath(entry.DN)
+	case len(s.treePathAttributes) > 0:
+		return attributeTreePath(entry, s.treePathAttributes)
+	}
+	return orgTreePath(entry)
+}
+
+// orgTreePath строит путь по атрибутам "o" и "ou"
+func orgTreePath(entry *ldap.Entry) []treeLevel {
+	o := quotRemove(entry.GetAttributeValue("o"))
+	ou := quotRemove(entry.GetAttributeValue("ou"))
+
+	orgParts := strings.SplitN(o, ",", 2)
+	orgName := strings.TrimSpace(orgParts[0])
+	var deptName string
+	if len(orgParts) > 1 {
+		deptName = strings.TrimSpace(orgParts[1])
+	}
+
+	// Для уровня организации люди не показываются
+	path := []treeLevel{{Name: orgName}}
+	if deptName != "" {
+		// Организация с подразделениями
+		path = append(path, treeLevel{Name: deptName, Filter: "(o=" + escapeFilter(o) + ")"})
+		if ou != "" {
+			path = append(path, treeLevel{Name: ou, Filter: "(&(o=" + escapeFilter(o) + ")(ou=" + escapeFilter(ou) + "))"})
+		}
+	} else if ou != "" {
+		// Отдел непосредственно в организации
+		path = append(path, treeLevel{Name: ou, Filter: "(&(o=" + escapeFilter(o) + ")(ou=" + escapeFilter(ou) + "))"})
+	}
+	return path
+}
+
+// attributeTreePath строит путь по значениям атрибутов в заданном порядке.
+// Путь заканчивается на первом незаполненном атрибуте
+func attributeTreePath(entry *ldap.Entry, attributes []string) []treeLevel {
+	var path []treeLevel
+	var conditions string
+	for _, attr := range attributes {
+		value := strings.TrimSpace(quotRemove(entry.GetAttributeValue(attr)))
+		if value == "" {
+			break
+		}
+		conditions += "(" + attr + "=" + escapeFilter(value) + ")"
+		filter := conditions
+		if len(path) > 0 {
+			filter = "(&" + conditions + ")"
+		}
+		path = append(path, treeLevel{Name: value, Filter: filter})
+	}
+	return path
+}
+
+// dnTreePath строит путь по DN записи: каждый уровень ниже базы источника
+// (ou=..., o=...) становится узлом, люди узла ищутся в его поддереве
+func (s *source) dnTreePath(dn string) []treeLevel {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return nil
+	}
+	depth := 0
+	if base, err := ldap.ParseDN(s.BaseDN); err == nil {
+		depth = len(base.RDNs)
+	}
+
+	// Первый RDN - сама запись
+	var path []treeLevel
+	for i := len(parsed.RDNs) - depth - 1; i >= 1; i-- {
+		rdn := parsed.RDNs[i]
+		if len(rdn.Attributes) == 0 {
+			continue
+		}
+		path = append(path, treeLevel{Name: rdn.Attributes[0].Value, Base: formatDN(parsed.RDNs[i:])})
+	}
+	return path
+}
+
+// formatDN собирает строку DN из разобранных RDN
+func formatDN(rdns []*ldap.RelativeDN) string {
+	parts := make([]string, 0, len(rdns))
+	for _, rdn := range rdns {
+		var values []string
+		for _, attr := range rdn.Attributes {
+			values = append(values, attr.Type+"="+escapeDNValue(attr.Value))
+		}
+		parts = append(parts, strings.Join(values, "+"))
+	}
+	return strings.Join(parts, ",")
+}
+
+// fetchNodePeople возвращает людей узла дерева источника вместе с вложенными узлами
+func fetchNodePeople(src *source, node *OrgNode) ([]LDAPEntry, error) {
+	return fetchEntriesFrom([]*source{src}, node.Base, ldap.ScopeWholeSubtree, "(&"+entryFilter()+node.Filter+")")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchOrgTreeHeadcount(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "people.ldif")
+	data := `dn: cn=Иванов Иван,dc=example
+objectClass: inetOrgPerson
+cn: Иванов Иван
+o: Рога, Филиал
+ou: ИТ
+
+dn: cn=Петров Петр,dc=example
+objectClass: inetOrgPerson
+cn: Петров Петр
+o: Рога, Филиал
+
+dn: cn=Переговорная 1,dc=example
+objectClass: room
+cn: Переговорная 1
+o: Рога, Филиал
+ou: ИТ
+`
+	if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := newDirectory(SourceConfig{Backend: backendLDIF, DataFile: file, BaseDN: "dc=example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := entryTypes
+	entryTypes, err = newEntryTypes([]EntryTypeConfig{{Name: "room", Filter: "(objectClass=room)"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { entryTypes = saved }()
+
+	// Переговорная показывается в отделе, но не считается сотрудником
+	s := &source{Name: "test", BaseDN: "dc=example", dir: dir}
+	root, err := s.fetchOrgTree(false, groupingOrg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch := root.Children["Рога"].Children["Филиал"]
+	if branch == nil || branch.Children["ИТ"] == nil {
+		t.Fatalf("нет узла Рога/Филиал/ИТ")
+	}
+	checks := []struct {
+		node          *OrgNode
+		direct, total int
+	}{
+		{root, 0, 2},
+		{branch, 1, 2},
+		{branch.Children["ИТ"], 1, 1},
+	}
+	for _, c := range checks {
+		if c.node.Direct != c.direct || c.node.Total != c.total {
+			t.Errorf("%s: %d / %d, ожидалось %d / %d", c.node.Name, c.node.Direct, c.node.Total, c.direct, c.total)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+const (
+	// Время показа всплывающего окна по умолчанию, секунд
+	defaultPopupTimeout = 15
+	// Количество последних цифр номера, по которым ищется звонящий
+	phoneMatchDigits = 7
+)
+
+// handleIncomingCall ищет звонящего по номеру и показывает всплывающее окно
+func handleIncomingCall(number string) {
+	people, err := lookupPhone(number)
+	if err != nil {
+		fmt.Printf("Ошибка поиска звонящего %s: %v\n", number, err)
+	}
+
+	glib.IdleAdd(func() {
+		showIncomingPopup(number, people)
+	})
+}
+
+// lookupPhone ищет людей по номеру телефона без учета форматирования номера
+func lookupPhone(number string) ([]LDAPEntry, error) {
+	digits := phoneDigits(number)
+	if digits == "" {
+		return nil, fmt.Errorf("некорректный номер: %s", number)
+	}
+
+	// Сравниваем последние цифры: в LDAP номер может храниться
+	// с кодом страны, скобками и дефисами
+	tail := digits
+	if len(tail) > phoneMatchDigits {
+		tail = tail[len(tail)-phoneMatchDigits:]
+	}
+	filter := "(telephoneNumber=*" + strings.Join(strings.Split(tail, ""), "*") + "*)"
+
+	entries, err := fetchPeople(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []LDAPEntry
+	for _, entry := range entries {
+		if phoneMatches(entry.TelephoneNumber, digits) {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+// phoneDigits оставляет в номере только цифры
+func phoneDigits(number string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, number)
+}
+
+// phoneMatches сравнивает номера: короткие (внутренние) целиком,
+// длинные - по последним цифрам
+func phoneMatches(phone, digits string) bool {
+	p := phoneDigits(phone)
+	if p == "" {
+		return false
+	}
+	if len(p) <= phoneMatchDigits || len(digits) <= phoneMatchDigits {
+		return p == digits
+	}
+	tail := digits[len(digits)-phoneMatchDigits:]
+	return strings.HasSuffix(p, tail)
+}
+
+// showIncomingPopup показывает всплывающее окно с карточкой звонящего.
+// Вызывается только из основного потока GTK
+func showIncomingPopup(number string, people []LDAPEntry) {
+	popup, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	if err != nil {
+		fmt.Printf("Ошибка создания всплывающего окна: %v\n", err)
+		return
+	}
+
+	popup.SetTitle("Входящий звонок")
+	popup.SetTypeHint(gdk.WINDOW_TYPE_HINT_NOTIFICATION)
+	popup.SetDecorated(false)
+	popup.SetKeepAbove(true)
+	popup.SetSkipTaskbarHint(true)
+	popup.SetAcceptFocus(false)
+	popup.SetResizable(false)
+	popup.SetBorderWidth(10)
+
+	// Главное окно может быть не создано, если программа запущена только для уведомления
+	popup.Connect("destroy", func() {
+		if mainWindow == nil {
+			gtk.MainQuit()
+		}
+	})
+
+	box, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 5)
+	if err != nil {
+		fmt.Printf("Ошибка создания контейнера: %v\n", err)
+		return
+	}
+
+	header, err := gtk.LabelNew("")
+	if err != nil {
+		fmt.Printf("Ошибка создания метки: %v\n", err)
+		return
+	}
+	header.SetMarkup("<b>Входящий звонок: " + html.EscapeString(number) + "</b>")
+	header.SetHAlign(gtk.ALIGN_START)
+	box.PackStart(header, false, false, 0)
+
+	if len(people) == 0 {
+		label, err := gtk.LabelNew("Номер не найден в справочнике")
+		if err != nil {
+			fmt.Printf("Ошибка создания метки: %v\n", err)
+			return
+		}
+		label.SetHAlign(gtk.ALIGN_START)
+		box.PackStart(label, false, false, 0)
+	}
+
+	for _, person := range people {
+		label, err := gtk.LabelNew("")
+		if err != nil {
+			fmt.Printf("Ошибка создания метки: %v\n", err)
+			return
+		}
+		label.SetMarkup(formatCallerMarkup(person))
+		label.SetHAlign(gtk.ALIGN_START)
+		label.SetLineWrap(true)
+		box.PackStart(label, false, false, 0)
+	}
+
+	buttons, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 5)
+	if err != nil {
+		fmt.Printf("Ошибка создания контейнера: %v\n", err)
+		return
+	}
+
+	// Кнопка "Открыть" показывает найденных людей в главном окне
+	if len(people) > 0 && mainWindow != nil {
+		openButton, err := gtk.ButtonNewWithLabel("Открыть")
+		if err != nil {
+			fmt.Printf("Ошибка создания кнопки: %v\n", err)
+			return
+		}
+		openButton.Connect("clicked", func() {
+			showPeople(people)
+			restoreFromTray()
+			popup.Destroy()
+		})
+		buttons.PackEnd(openButton, false, false, 0)
+	}
+
+	closeButton, err := gtk.ButtonNewWithLabel("Закрыть")
+	if err != nil {
+		fmt.Printf("Ошибка создания кнопки: %v\n", err)
+		return
+	}
+	closeButton.Connect("clicked", func() {
+		popup.Destroy()
+	})
+	buttons.PackEnd(closeButton, false, false, 0)
+	box.PackStart(buttons, false, false, 0)
+
+	popup.Add(box)
+	popup.ShowAll()
+	movePopupToCorner(popup)
+
+	timeout := config.PopupTimeout
+	if timeout <= 0 {
+		timeout = defaultPopupTimeout
+	}
+	glib.TimeoutAdd(uint(timeout*1000), func() bool {
+		popup.Destroy()
+		return false
+	})
+}
+
+// formatCallerMarkup формирует краткую карточку звонящего в разметке Pango
+func formatCallerMarkup(person LDAPEntry) string {
+	var lines []string
+	lines = append(lines, "<big><b>"+html.EscapeString(person.CN)+"</b></big>")
+	if person.Title != "" {
+		lines = append(lines, html.EscapeString(person.Title))
+	}
+	if person.OU != "" {
+		lines = append(lines, "Отдел: "+html.EscapeString(person.OU))
+	}
+	if person.O != "" {
+		lines = append(lines, "Организация: "+html.EscapeString(person.O))
+	}
+	if person.TelephoneNumber != "" {
+		lines = append(lines, "Телефон: "+html.EscapeString(person.TelephoneNumber))
+	}
+	if person.Mail != "" {
+		lines = append(lines, "Email: "+html.EscapeString(person.Mail))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// movePopupToCorner размещает окно в правом нижнем углу рабочей области
+func movePopupToCorner(popup *gtk.Window) {
+	display, err := gdk.DisplayGetDefault()
+	if err != nil {
+		return
+	}
+	monitor, err := display.GetPrimaryMonitor()
+	if err != nil || monitor == nil {
+		return
+	}
+	area := monitor.GetWorkarea()
+	width, height := popup.GetSize()
+	popup.Move(area.GetX()+area.GetWidth()-width-10, area.GetY()+area.GetHeight()-height-10)
+}
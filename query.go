@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"gopkg.in/ldap.v2"
+)
+
+// Атрибуты поиска по умолчанию
+var defaultSearchAttributes = []string{"cn", "mail", "telephoneNumber", "title", "ou", "o", "l", "postalAddress"}
+
+// Префиксы полей в строке поиска и соответствующие им атрибуты
+var queryFields = map[string]string{
+	"name":        "cn",
+	"cn":          "cn",
+	"фио":         "cn",
+	"title":       "title",
+	"должность":   "title",
+	"dept":        "ou",
+	"ou":          "ou",
+	"отдел":       "ou",
+	"org":         "o",
+	"o":           "o",
+	"организация": "o",
+	"city":        "l",
+	"l":           "l",
+	"город":       "l",
+	"phone":       "telephoneNumber",
+	"tel":         "telephoneNumber",
+	"телефон":     "telephoneNumber",
+	"mail":        "mail",
+	"email":       "mail",
+	"address":     "postalAddress",
+	"адрес":       "postalAddress",
+}
+
+// queryTerm условие строки поиска: слово, фраза в кавычках или поле:значение
+type queryTerm struct {
+	field   string
+	value   string
+	quoted  bool
+	negated bool
+}
+
+// queryFilter разбирает строку поиска и формирует LDAP фильтр.
+//
+// Строка, начинающаяся с "(", передается как готовый фильтр LDAP.
+// Иначе строка разбивается на условия, которые должны выполняться все:
+//
+//	title:инженер dept:"ИТ отдел" city:Москва -mail:*test*
+//
+// Слово без префикса ищется по атрибутам поиска (параметр search_attributes,
+// см. searchAttributes), "-" перед условием
+// исключает подходящие записи, "*" в значении без кавычек задает шаблон.
+// Обычный текст без префиксов, кавычек и "-" ищется целиком и по словам ФИО
+// (см. searchPlainText); для него plain=true
+func queryFilter(text string) (filter string, plain bool, err error) {
+	if trimmed := strings.TrimSpace(text); strings.HasPrefix(trimmed, "(") {
+		if _, err := ldap.CompileFilter(trimmed); err != nil {
+			return "", false, fmt.Errorf("Неверный фильтр LDAP: %v", err)
+		}
+		return trimmed, false, nil
+	}
+
+	terms := parseQuery(text)
+	if plainQuery(terms) {
+		if strings.Trim(text, "* \t") == "" {
+			return "", false, fmt.Errorf("Задайте текст для поиска")
+		}
+		return textFilter(text), true, nil
+	}
+
+	var parts []string
+	for _, term := range terms {
+		if term.field != "" && term.value == "" && !term.quoted {
+			return "", false, fmt.Errorf("Не задано значение поля %s", term.field)
+		}
+		part := patternFilter(term.pattern())
+		if term.field != "" {
+			part = "(" + term.field + "=" + term.pattern() + ")"
+		}
+		if term.negated {
+			part = "(!" + part + ")"
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 1 {
+		return parts[0], false, nil
+	}
+	return "(&" + strings.Join(parts, "") + ")", false, nil
+}
+
+// searchAttributes возвращает атрибуты, по которым ищет строка поиска
+func searchAttributes() []string {
+	if len(config.SearchAttributes) > 0 {
+		return config.SearchAttributes
+	}
+	return defaultSearchAttributes
+}
+
+// plainQuery проверяет, что строка поиска - обычный текст без префиксов, кавычек и "-"
+func plainQuery(terms []queryTerm) bool {
+	for _, term := range terms {
+		if term.field != "" || term.quoted || term.negated {
+			return false
+		}
+	}
+	return true
+}
+
+// textTerm возвращает условие поиска подстроки для обычного текста
+func textTerm(text string) queryTerm {
+	return queryTerm{value: "*" + text + "*"}
+}
+
+// pattern возвращает экранированный шаблон значения. Значение без "*"
+// и фраза в кавычках ищутся как подстрока. Пустое значение становится
+// шаблоном "*", то есть проверкой наличия атрибута
+func (t queryTerm) pattern() string {
+	if t.quoted || !strings.Contains(t.value, "*") {
+		return wildcard("*" + escapeFilter(t.value) + "*")
+	}
+	parts := strings.Split(t.value, "*")
+	for i, part := range parts {
+		parts[i] = escapeFilter(part)
+	}
+	return wildcard(strings.Join(parts, "*"))
+}
+
+// parseQuery разбивает строку поиска на условия
+func parseQuery(text string) []queryTerm {
+	var terms []queryTerm
+	runes := []rune(text)
+	i := 0
+	for {
+		for i < len(runes) && unicode.IsSpace(runes[i]) {
+			i++
+		}
+		if i == len(runes) {
+			return terms
+		}
+
+		var term queryTerm
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			term.negated = true
+			i++
+		}
+
+		// Префикс поля до ":", неизвестный префикс считается частью слова
+		start := i
+		for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != ':' && runes[i] != '"' {
+			i++
+		}
+		if i < len(runes) && runes[i] == ':' && i > start {
+			if attr, ok := queryFields[strings.ToLower(string(runes[start:i]))]; ok {
+				term.field = attr
+				start = i + 1
+			}
+		}
+		i = start
+
+		if i < len(runes) && runes[i] == '"' {
+			// Фраза до закрывающей кавычки или до конца строки
+			term.quoted = true
+			i++
+			start = i
+			for i < len(runes) && runes[i] != '"' {
+				i++
+			}
+			term.value = string(runes[start:i])
+			if i < len(runes) {
+				i++
+			}
+		} else {
+			for i < len(runes) && !unicode.IsSpace(runes[i]) {
+				i++
+			}
+			term.value = string(runes[start:i])
+		}
+		terms = append(terms, term)
+	}
+}
+
+// matches проверяет значение атрибута на совпадение с условием без учета регистра
+func (t queryTerm) matches(value string) bool {
+	value = strings.ToLower(value)
+	pattern := strings.ToLower(t.value)
+	if value == "" {
+		return false
+	}
+	if t.quoted || !strings.Contains(pattern, "*") {
+		return strings.Contains(value, pattern)
+	}
+
+	// Части шаблона должны идти по порядку, первая - с начала значения, последняя - в конце
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(value, parts[0]) || !strings.HasSuffix(value, parts[len(parts)-1]) {
+		return false
+	}
+	pos := len(parts[0])
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value[pos:], part)
+		if i < 0 {
+			return false
+		}
+		pos += i + len(part)
+	}
+	return pos <= len(value)-len(parts[len(parts)-1])
+}
+
+// markMatches отмечает в найденных записях поля, совпавшие со строкой поиска
+func markMatches(entries []LDAPEntry, text string) {
+	if strings.HasPrefix(strings.TrimSpace(text), "(") {
+		return
+	}
+	terms := parseQuery(text)
+	if plainQuery(terms) {
+		terms = []queryTerm{textTerm(text)}
+	}
+	for i := range entries {
+		entries[i].Match = matchedFields(entries[i], terms)
+	}
+}
+
+// matchedFields возвращает названия полей записи, совпавших с условиями поиска
+func matchedFields(entry LDAPEntry, terms []queryTerm) string {
+	var titles []string
+	seen := map[string]bool{}
+	for _, term := range terms {
+		if term.negated {
+			continue
+		}
+		names := searchAttributes()
+		if term.field != "" {
+			names = []string{term.field}
+		}
+		for _, name := range names {
+			if !seen[strings.ToLower(name)] && term.matches(entryAttribute(entry, name)) {
+				seen[strings.ToLower(name)] = true
+				titles = append(titles, fieldTitle(name))
+			}
+		}
+	}
+	return strings.Join(titles, ", ")
+}
+
+// fieldTitle возвращает название поля для атрибута
+func fieldTitle(name string) string {
+	for _, field := range searchFields {
+		if strings.EqualFold(field.Attribute, name) {
+			return field.Title
+		}
+	}
+	return name
+}
+
+// keepAttributes сохраняет в записи значения атрибутов, для которых нет
+// отдельного поля: атрибутов поиска, чтобы показать их среди совпавших полей,
+// и атрибутов дерева, чтобы искать в узле дерева по локальному кэшу
+func keepAttributes(item *LDAPEntry, entry *ldap.Entry, names []string) {
+	for _, name := range names {
+		if entryAttribute(*item, name) != "" {
+			continue
+		}
+		if value := entry.GetAttributeValue(name); value != "" {
+			if item.Attributes == nil {
+				item.Attributes = map[string]string{}
+			}
+			item.Attributes[strings.ToLower(name)] = value
+		}
+	}
+}
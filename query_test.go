@@ -0,0 +1,132 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/ldap.v2"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want []queryTerm
+	}{
+		{"Иванов", []queryTerm{{value: "Иванов"}}},
+		{"  Иван   Петров ", []queryTerm{{value: "Иван"}, {value: "Петров"}}},
+		{"title:инженер", []queryTerm{{field: "title", value: "инженер"}}},
+		{"Отдел:ИТ", []queryTerm{{field: "ou", value: "ИТ"}}},
+		{`dept:"ИТ отдел" city:Москва`, []queryTerm{{field: "ou", value: "ИТ отдел", quoted: true}, {field: "l", value: "Москва"}}},
+		{"-mail:*test*", []queryTerm{{field: "mail", value: "*test*", negated: true}}},
+		{"-Иванов", []queryTerm{{value: "Иванов", negated: true}}},
+		{"- Иванов", []queryTerm{{value: "-"}, {value: "Иванов"}}},
+		{"Петров-Водкин", []queryTerm{{value: "Петров-Водкин"}}},
+		{"foo:bar", []queryTerm{{value: "foo:bar"}}},
+		{`"Иван Петров`, []queryTerm{{value: "Иван Петров", quoted: true}}},
+		{`dept:""`, []queryTerm{{field: "ou", quoted: true}}},
+	}
+	for _, tt := range tests {
+		if got := parseQuery(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseQuery(%q) = %+v, ожидалось %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestQueryFilter(t *testing.T) {
+	tests := []struct {
+		text  string
+		want  string
+		plain bool
+	}{
+		{"Иванов", patternFilter("*Иванов*"), true},
+		{"Ив*ов", patternFilter("*Ив*ов*"), true},
+		{"ООО Ромашка (Казань)", patternFilter(`*ООО Ромашка \28Казань\29*`), true},
+		{"Иванов)(objectClass=*", patternFilter(`*Иванов\29\28objectClass=*`), true},
+		{`a\b`, patternFilter(`*a\5cb*`), true},
+		{"title:инженер", "(title=*инженер*)", false},
+		{"title:инж*", "(title=инж*)", false},
+		{"title:*", "(title=*)", false},
+		{`dept:""`, "(ou=*)", false},
+		{`-dept:""`, "(!(ou=*))", false},
+		{`dept:"(ИТ)*"`, `(ou=*\28ИТ\29\2a*)`, false},
+		{"-mail:*test*", "(!(mail=*test*))", false},
+		{"-Иванов", "(!" + patternFilter("*Иванов*") + ")", false},
+		{"title:инженер -city:Москва", "(&(title=*инженер*)(!(l=*Москва*)))", false},
+		{" (cn=Иванов) ", "(cn=Иванов)", false},
+	}
+	for _, tt := range tests {
+		filter, plain, err := queryFilter(tt.text)
+		if err != nil {
+			t.Errorf("queryFilter(%q): %v", tt.text, err)
+			continue
+		}
+		if filter != tt.want || plain != tt.plain {
+			t.Errorf("queryFilter(%q) = %q, %v, ожидалось %q, %v", tt.text, filter, plain, tt.want, tt.plain)
+		}
+		if _, err := ldap.CompileFilter(filter); err != nil {
+			t.Errorf("queryFilter(%q) = %q: неверный фильтр: %v", tt.text, filter, err)
+		}
+	}
+}
+
+func TestQueryFilterErrors(t *testing.T) {
+	for _, text := range []string{"**", " * ", "title:", "(cn=Иванов", "(cn=a)(cn=b"} {
+		if filter, _, err := queryFilter(text); err == nil {
+			t.Errorf("queryFilter(%q) = %q, ожидалась ошибка", text, filter)
+		}
+	}
+}
+
+func TestQueryTermMatches(t *testing.T) {
+	tests := []struct {
+		term  queryTerm
+		value string
+		want  bool
+	}{
+		{queryTerm{value: "иван"}, "Иванов Иван", true},
+		{queryTerm{value: "ИВАН"}, "иванов", true},
+		{queryTerm{value: "петр"}, "Иванов", false},
+		{queryTerm{value: "ив*ов"}, "Иванов", true},
+		{queryTerm{value: "ив*ов"}, "Иванова", false},
+		{queryTerm{value: "*ов"}, "Петров", true},
+		{queryTerm{value: "*test*"}, "my.test@example.com", true},
+		{queryTerm{value: "a*b*c"}, "abc", true},
+		{queryTerm{value: "a*b*c"}, "ac", false},
+		{queryTerm{value: "ab*bc"}, "abc", false},
+		{queryTerm{value: "*", quoted: true}, "*", true},
+		{queryTerm{value: "*", quoted: true}, "abc", false},
+		{textTerm("ив*ов"), "Сидоров Иван Иванович", true},
+		{queryTerm{value: "иван"}, "", false},
+	}
+	for _, tt := range tests {
+		if got := tt.term.matches(tt.value); got != tt.want {
+			t.Errorf("%+v.matches(%q) = %v, ожидалось %v", tt.term, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMatchedFields(t *testing.T) {
+	entry := LDAPEntry{CN: "Иванов Иван", Mail: "ivanov@example.com", Title: "Инженер", L: "Москва"}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"иванов", "ФИО"},
+		{"ivanov", "Email"},
+		{"инж", "Должность"},
+		{"city:моск", "Город"},
+		{"-city:моск инж", "Должность"},
+		{"петров", ""},
+	}
+	for _, tt := range tests {
+		entries := []LDAPEntry{entry}
+		markMatches(entries, tt.text)
+		if entries[0].Match != tt.want {
+			t.Errorf("markMatches(%q) = %q, ожидалось %q", tt.text, entries[0].Match, tt.want)
+		}
+	}
+	if want := fieldTitle("cn"); !strings.Contains(matchedFields(entry, []queryTerm{textTerm("иван")}), want) {
+		t.Errorf("matchedFields не нашел поле %s", want)
+	}
+}
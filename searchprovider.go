@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/gotk3/gotk3/glib"
+)
+
+const (
+	searchProviderPath      = dbus.ObjectPath("/io/github/imax1000/LdapPhonebook/SearchProvider")
+	searchProviderInterface = "org.gnome.Shell.SearchProvider2"
+	// Значок для записей без фотографии
+	defaultAvatarIcon = "avatar-default"
+	// Количество результатов, отдаваемых в обзор GNOME Shell
+	searchProviderMaxResults = 20
+	// Число одновременных запросов фотографий
+	photoWorkers = 4
+)
+
+// Ограничение одновременных запросов фотографий
+var photoSlots = make(chan struct{}, photoWorkers)
+
+const searchProviderIntrospectXML = `
+<node>
+	<interface name="` + searchProviderInterface + `">
+		<method name="GetInitialResultSet">
+			<arg name="terms" type="as" direction="in"/>
+			<arg name="results" type="as" direction="out"/>
+		</method>
+		<method name="GetSubsearchResultSet">
+			<arg name="previous_results" type="as" direction="in"/>
+			<arg name="terms" type="as" direction="in"/>
+			<arg name="results" type="as" direction="out"/>
+		</method>
+		<method name="GetResultMetas">
+			<arg name="identifiers" type="as" direction="in"/>
+			<arg name="metas" type="aa{sv}" direction="out"/>
+		</method>
+		<method name="ActivateResult">
+			<arg name="identifier" type="s" direction="in"/>
+			<arg name="terms" type="as" direction="in"/>
+			<arg name="timestamp" type="u" direction="in"/>
+		</method>
+		<method name="LaunchSearch">
+			<arg name="terms" type="as" direction="in"/>
+			<arg name="timestamp" type="u" direction="in"/>
+		</method>
+	</interface>` + introspect.IntrospectDataString + `</node>`
+
+// searchProvider реализует org.gnome.Shell.SearchProvider2.
+// Идентификатор результата - DN записи
+type searchProvider struct {
+	mu sync.Mutex
+	// Записи результатов текущего поиска
+	entries map[string]LDAPEntry
+	// Фотографии, которые загружаются сейчас, и записи без фотографии
+	photoPending map[string]bool
+	noPhoto      map[string]bool
+}
+
+// exportSearchProvider публикует поисковый провайдер GNOME Shell
+func exportSearchProvider() {
+	if sessionBus == nil {
+		return
+	}
+
+	provider := &searchProvider{
+		entries:      make(map[string]LDAPEntry),
+		photoPending: make(map[string]bool),
+		noPhoto:      make(map[string]bool),
+	}
+	if err := sessionBus.Export(provider, searchProviderPath, searchProviderInterface); err != nil {
+		fmt.Printf("Ошибка публикации поискового провайдера: %v\n", err)
+		return
+	}
+	if err := sessionBus.Export(introspect.Introspectable(searchProviderIntrospectXML), searchProviderPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		fmt.Printf("Ошибка публикации поискового провайдера: %v\n", err)
+	}
+}
+
+// search ищет людей так же, как строка поиска главного окна.
+// Новый поиск (initial) заменяет запомненные записи прежнего
+func (p *searchProvider) search(terms []string, initial bool) ([]string, *dbus.Error) {
+	text := strings.TrimSpace(strings.Join(terms, " "))
+	if text == "" {
+		return []string{}, nil
+	}
+
+	entries, err := searchByText(text)
+	if err != nil {
+		return nil, dbus.MakeFailedError(err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if initial {
+		p.entries = make(map[string]LDAPEntry)
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		if len(ids) == searchProviderMaxResults {
+			break
+		}
+		p.entries[entry.DN] = entry
+		ids = append(ids, entry.DN)
+	}
+	return ids, nil
+}
+
+func (p *searchProvider) GetInitialResultSet(terms []string) ([]string, *dbus.Error) {
+	return p.search(terms, true)
+}
+
+func (p *searchProvider) GetSubsearchResultSet(previous []string, terms []string) ([]string, *dbus.Error) {
+	return p.search(terms, false)
+}
+
+func (p *searchProvider) GetResultMetas(ids []string) ([]map[string]dbus.Variant, *dbus.Error) {
+	metas := []map[string]dbus.Variant{}
+	for _, id := range ids {
+		p.mu.Lock()
+		entry, ok := p.entries[id]
+		p.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		var description []string
+		for _, s := range []string{entry.Title, entry.OU, entry.O} {
+			if s != "" {
+				description = append(description, s)
+			}
+		}
+
+		metas = append(metas, map[string]dbus.Variant{
+			"id":          dbus.MakeVariant(id),
+			"name":        dbus.MakeVariant(entry.CN),
+			"description": dbus.MakeVariant(strings.Join(description, ", ")),
+			"gicon":       dbus.MakeVariant(p.avatarIcon(id)),
+		})
+	}
+	return metas, nil
+}
+
+func (p *searchProvider) ActivateResult(id string, terms []string, timestamp uint32) *dbus.Error {
+	if _, err := runControlCommand(controlRequest{Command: "show", DN: id}); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	glib.IdleAdd(restoreFromTray)
+	return nil
+}
+
+func (p *searchProvider) LaunchSearch(terms []string, timestamp uint32) *dbus.Error {
+	query := strings.Join(terms, " ")
+	if _, err := runControlCommand(controlRequest{Command: "search", Query: query}); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	glib.IdleAdd(restoreFromTray)
+	return nil
+}
+
+// avatarIcon возвращает путь к файлу с фотографией записи
+// или имя стандартного значка, если фотографии нет. Ответ не ждет
+// загрузки фотографии: она загружается в фоне и показывается при следующем поиске
+func (p *searchProvider) avatarIcon(dn string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return defaultAvatarIcon
+	}
+	file := filepath.Join(dir, appName, "avatars", fmt.Sprintf("%x.jpg", sha1.Sum([]byte(dn))))
+	if _, err := os.Stat(file); err == nil {
+		return file
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.noPhoto[dn] && !p.photoPending[dn] {
+		p.photoPending[dn] = true
+		go p.loadPhoto(dn, file)
+	}
+	return defaultAvatarIcon
+}
+
+// loadPhoto загружает фотографию записи в файл кэша
+func (p *searchProvider) loadPhoto(dn, file string) {
+	photoSlots <- struct{}{}
+	photo, err := fetchPhoto(dn)
+	<-photoSlots
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.photoPending, dn)
+	if err != nil {
+		// Ошибку связи не запоминаем, фотография будет запрошена снова
+		return
+	}
+	if len(photo) == 0 {
+		p.noPhoto[dn] = true
+		return
+	}
+	os.MkdirAll(filepath.Dir(file), 0700)
+	os.WriteFile(file, photo, 0600)
+}
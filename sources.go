@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/asn1-ber.v1"
+	"gopkg.in/ldap.v2"
+)
+
+// Фильтр записей сотрудников, с которым работает программа
+const personFilter = "(objectClass=inetOrgPerson)"
+
+// Заголовок корня дерева, если источник не имеет имени,
+// при группировке по организациям
+const defaultTreeTitle = "Организации и отделы"
+
+// SourceConfig настройки именованного источника данных
+type SourceConfig struct {
+	Name         string `json:"name"`
+	Backend      string `json:"backend"`
+	LDAPServer   string `json:"ldap_server"`
+	BindDN       string `json:"bind_dn"`
+	BindPassword string `json:"bind_password"`
+	BaseDN       string `json:"base_dn"`
+	DataFile     string `json:"data_file"`
+	// Атрибуты источника для атрибутов программы, например {"o": "company", "ou": "department"}
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Фильтр записей сотрудников, если в источнике это не inetOrgPerson
+	PersonFilter string `json:"person_filter,omitempty"`
+	// Построение дерева источника, как в основном конфиге
+	TreeAttributes []string `json:"tree_attributes,omitempty"`
+	TreeFromDN     bool     `json:"tree_from_dn,omitempty"`
+}
+
+// source источник данных справочника с собственной базой поиска
+type source struct {
+	Name   string
+	BaseDN string
+	dir    Directory
+	// Построение дерева: по DN или по списку атрибутов
+	treeFromDN         bool
+	treePathAttributes []string
+}
+
+// Источники данных в порядке приоритета из конфига
+var sources []*source
+
+// newSources создает источники данных по конфигу. Если список sources
+// не задан, используется единственный источник из основных параметров
+func newSources(cfg Config) ([]*source, error) {
+	configs := cfg.Sources
+	if len(configs) == 0 {
+		configs = []SourceConfig{{
+			Backend:      cfg.Backend,
+			LDAPServer:   cfg.LDAPServer,
+			BindDN:       cfg.BindDN,
+			BindPassword: cfg.BindPassword,
+			BaseDN:       cfg.BaseDN,
+			DataFile:     cfg.DataFile,
+		}}
+	}
+
+	var result []*source
+
+	names := map[string]bool{}
+	for _, sc := range configs {
+		if len(configs) > 1 {
+			if sc.Name == "" {
+				return nil, fmt.Errorf("Не задано имя источника данных")
+			}
+			if names[sc.Name] {
+				return nil, fmt.Errorf("Повторяется имя источника данных: %s", sc.Name)
+			}
+			names[sc.Name] = true
+		}
+
+		dir, err := newDirectory(sc)
+		if err != nil {
+			return nil, err
+		}
+		if len(sc.Attributes) > 0 || sc.PersonFilter != "" {
+			dir, err = newMappedDirectory(dir, sc.Attributes, sc.PersonFilter)
+			if err != nil {
+				return nil, fmt.Errorf("Источник %s: %v", sc.Name, err)
+			}
+		}
+
+		// Настройки дерева источника, если не заданы, берутся из основного конфига
+		s := &source{Name: sc.Name, BaseDN: sc.BaseDN, dir: dir, treeFromDN: cfg.TreeFromDN, treePathAttributes: cfg.TreeAttributes}
+		if sc.TreeFromDN || len(sc.TreeAttributes) > 0 {
+			s.treeFromDN = sc.TreeFromDN
+			s.treePathAttributes = sc.TreeAttributes
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// title возвращает заголовок корня дерева источника при группировке grouping
+func (s *source) title(grouping string) string {
+	if s.Name == "" {
+		return groupingName(grouping)
+	}
+	return s.Name
+}
+
+// sourceOfRoot ищет источник корня дерева. Ключ корня не зависит
+// от группировки, поэтому поиск работает и во время перезагрузки дерева
+func sourceOfRoot(root *OrgNode) *source {
+	for _, s := range sources {
+		if s.nodeKey(nil, treeLevel{}) == root.Key {
+			return s
+		}
+	}
+	return nil
+}
+
+// sourcesForDN возвращает источники, в базу которых входит DN.
+// Если таких нет, возвращаются все источники
+func sourcesForDN(dn string) []*source {
+	dn = normalizeDN(dn)
+	var result []*source
+	for _, s := range sources {
+		if inScope(dn, normalizeDN(s.BaseDN), ldap.ScopeWholeSubtree) {
+			result = append(result, s)
+		}
+	}
+	if len(result) == 0 {
+		return sources
+	}
+	return result
+}
+
+// fetchEntriesFrom выполняет поиск одновременно во всех указанных источниках.
+// Пустая база означает базу поиска каждого источника. Результаты объединяются,
+// записи с одинаковым email из менее приоритетных источников отбрасываются
+func fetchEntriesFrom(srcs []*source, baseDN string, scope int, filter string) ([]LDAPEntry, error) {
+	results := make([][]LDAPEntry, len(srcs))
+	errs := make([]error, len(srcs))
+
+	var wg sync.WaitGroup
+	for i, s := range srcs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			base := baseDN
+			if base == "" {
+				base = s.BaseDN
+			}
+			results[i], errs[i] = s.fetchEntries(base, scope, filter)
+		}()
+	}
+	wg.Wait()
+
+	var result []LDAPEntry
+	var firstErr error
+	seen := map[string]bool{}
+	for i, entries := range results {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			// Отсутствие записи в одном из источников ошибкой не считается
+			var ldapErr *ldap.Error
+			if len(srcs) > 1 && !(errors.As(errs[i], &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultNoSuchObject) {
+				log.Printf("Источник %s: %v\n", srcs[i].Name, errs[i])
+			}
+			continue
+		}
+		for _, entry := range entries {
+			if entry.Mail != "" {
+				mail := strings.ToLower(entry.Mail)
+				if seen[mail] {
+					continue
+				}
+				seen[mail] = true
+			}
+			result = append(result, entry)
+		}
+	}
+
+	// Ошибка возвращается, только если не ответил ни один источник
+	if len(srcs) > 0 && countErrors(errs) == len(srcs) {
+		return nil, firstErr
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CN < result[j].CN
+	})
+	return result, nil
+}
+
+func countErrors(errs []error) int {
+	n := 0
+	for _, err := range errs {
+		if err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// fetchOrgTrees загружает деревья организаций всех источников при
+// группировке grouping. Корень каждого дерева называется по источнику
+func fetchOrgTrees(lazy bool, grouping string) ([]*OrgNode, error) {
+	roots := make([]*OrgNode, len(sources))
+	errs := make([]error, len(sources))
+
+	var wg sync.WaitGroup
+	for i, s := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			roots[i], errs[i] = s.fetchOrgTree(lazy, grouping)
+		}()
+	}
+	wg.Wait()
+
+	var result []*OrgNode
+	for i, root := range roots {
+		if errs[i] != nil {
+			if len(sources) == 1 {
+				return nil, errs[i]
+			}
+			log.Printf("Источник %s: %v\n", sources[i].Name, errs[i])
+			continue
+		}
+		result = append(result, root)
+	}
+	if len(result) == 0 {
+		return nil, errs[0]
+	}
+	return result, nil
+}
+
+// mappedDirectory переводит имена атрибутов программы в имена атрибутов
+// источника, например для Active Directory партнеров
+type mappedDirectory struct {
+	Directory
+	// Атрибут источника для атрибута программы
+	attributes map[string]string
+	// Атрибут программы для атрибута источника (в нижнем регистре)
+	reverse map[string]string
+	person  *ber.Packet
+}
+
+func newMappedDirectory(dir Directory, attributes map[string]string, filter string) (*mappedDirectory, error) {
+	d := &mappedDirectory{
+		Directory:  dir,
+		attributes: map[string]string{},
+		reverse:    map[string]string{},
+	}
+	for name, mapped := range attributes {
+		name = attributeName(name)
+		d.attributes[strings.ToLower(name)] = mapped
+		d.reverse[strings.ToLower(mapped)] = name
+	}
+	if filter != "" {
+		packet, err := ldap.CompileFilter(filter)
+		if err != nil {
+			return nil, fmt.Errorf("неверный фильтр person_filter: %v", err)
+		}
+		d.person = packet
+	}
+	return d, nil
+}
+
+// attribute возвращает имя атрибута в источнике
+func (d *mappedDirectory) attribute(name string) string {
+	if mapped, ok := d.attributes[strings.ToLower(name)]; ok {
+		return mapped
+	}
+	return name
+}
+
+func (d *mappedDirectory) Search(baseDN string, scope int, filter string, attributes []string) ([]*ldap.Entry, error) {
+	packet, err := ldap.CompileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	filter, err = ldap.DecompileFilter(d.translate(packet))
+	if err != nil {
+		return nil, err
+	}
+
+	var mapped []string
+	for _, name := range attributes {
+		mapped = append(mapped, d.attribute(name))
+	}
+
+	entries, err := d.Directory.Search(baseDN, scope, filter, mapped)
+	if err != nil {
+		return nil, err
+	}
+
+	// Записи источника могут кэшироваться, поэтому создаем новые
+	result := make([]*ldap.Entry, 0, len(entries))
+	for _, entry := range entries {
+		item := &ldap.Entry{DN: entry.DN}
+		for _, attr := range entry.Attributes {
+			name, ok := d.reverse[strings.ToLower(attr.Name)]
+			if !ok {
+				if _, shadowed := d.attributes[strings.ToLower(attr.Name)]; shadowed {
+					continue
+				}
+				name = attr.Name
+			}
+			item.Attributes = append(item.Attributes, &ldap.EntryAttribute{
+				Name:       name,
+				Values:     attr.Values,
+				ByteValues: attr.ByteValues,
+			})
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+func (d *mappedDirectory) Values(baseDN string, filter string, attribute string) ([]string, error) {
+	packet, err := ldap.CompileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	filter, err = ldap.DecompileFilter(d.translate(packet))
+	if err != nil {
+		return nil, err
+	}
+	return d.Directory.Values(baseDN, filter, d.attribute(attribute))
+}
+
+// translate заменяет в скомпилированном фильтре имена атрибутов,
+// а условие (objectClass=inetOrgPerson) - фильтром сотрудников источника
+func (d *mappedDirectory) translate(packet *ber.Packet) *ber.Packet {
+	switch packet.Tag {
+	case ldap.FilterAnd, ldap.FilterOr, ldap.FilterNot:
+		for i, child := range packet.Children {
+			packet.Children[i] = d.translate(child)
+		}
+
+	case ldap.FilterPresent:
+		name := d.attribute(ber.DecodeString(packet.Data.Bytes()))
+		return ber.NewString(ber.ClassContext, ber.TypePrimitive, ldap.FilterPresent, name, ldap.FilterMap[ldap.FilterPresent])
+
+	case ldap.FilterEqualityMatch, ldap.FilterSubstrings, ldap.FilterGreaterOrEqual, ldap.FilterLessOrEqual, ldap.FilterApproxMatch:
+		name := ber.DecodeString(packet.Children[0].Data.Bytes())
+		if d.person != nil && packet.Tag == ldap.FilterEqualityMatch && strings.EqualFold(name, "objectClass") &&
+			strings.EqualFold(ber.DecodeString(packet.Children[1].Data.Bytes()), "inetOrgPerson") {
+			return d.person
+		}
+		packet.Children[0] = ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, d.attribute(name), "Attribute")
+	}
+	return packet
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Должности руководителей по умолчанию для сводки по отделу
+const defaultHeadTitlePattern = `(?i)(начальник|руководитель|директор|заведующ|head|director|chief)`
+
+// countTotals подсчитывает число сотрудников в узле вместе с вложенными
+func countTotals(node *OrgNode) int {
+	node.Total = node.Direct
+	for _, child := range node.Children {
+		node.Total += countTotals(child)
+	}
+	return node.Total
+}
+
+// headcountText формирует текст колонки численности: для узлов
+// с вложенными отделами через дробь показывается и число людей в самом узле
+func headcountText(node *OrgNode) string {
+	if node.Total < 0 {
+		// Численность узла, загруженного по DN, неизвестна
+		return ""
+	}
+	if len(node.Children) == 0 || node.Direct == 0 {
+		return fmt.Sprint(node.Total)
+	}
+	return fmt.Sprintf("%d / %d", node.Direct, node.Total)
+}
+
+// findDepartmentHead ищет руководителя отдела: по должности из параметра
+// head_title_pattern, а если такой нет - по атрибуту manager большинства сотрудников
+func findDepartmentHead(entries []LDAPEntry) string {
+	pattern := config.HeadTitlePattern
+	if pattern == "" {
+		pattern = defaultHeadTitlePattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		fmt.Printf("Ошибка в параметре head_title_pattern: %v\n", err)
+		re = regexp.MustCompile(defaultHeadTitlePattern)
+	}
+
+	// Руководитель выше по дереву важнее руководителей вложенных отделов
+	var head *LDAPEntry
+	for i, entry := range entries {
+		if re.MatchString(entry.Title) && (head == nil || len(entry.TreePath) < len(head.TreePath)) {
+			head = &entries[i]
+		}
+	}
+	if head != nil {
+		return head.CN + " (" + head.Title + ")"
+	}
+
+	managers := map[string]int{}
+	for _, entry := range entries {
+		if entry.Manager != "" {
+			managers[entry.Manager]++
+		}
+	}
+	var manager string
+	for dn, n := range managers {
+		if n > managers[manager] || n == managers[manager] && dn < manager {
+			manager = dn
+		}
+	}
+	if manager == "" {
+		return ""
+	}
+	for _, entry := range entries {
+		if strings.EqualFold(entry.DN, manager) {
+			return entry.CN + " (" + entry.Title + ")"
+		}
+	}
+	if entry, err := fetchPerson(manager); err == nil {
+		return entry.CN + " (" + entry.Title + ")"
+	}
+	return manager
+}
+
+// commonPhonePrefix возвращает общее начало телефонных номеров отдела
+func commonPhonePrefix(entries []LDAPEntry) string {
+	var prefix string
+	first := true
+	for _, entry := range entries {
+		if entry.TelephoneNumber == "" {
+			continue
+		}
+		if first {
+			prefix = entry.TelephoneNumber
+			first = false
+			continue
+		}
+		n := 0
+		for n < len(prefix) && n < len(entry.TelephoneNumber) && prefix[n] == entry.TelephoneNumber[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	prefix = strings.TrimRight(prefix, " -()")
+	if len(phoneDigits(prefix)) < 2 {
+		return ""
+	}
+	return prefix
+}
+
+// formatDepartmentSummary формирует сводку по узлу дерева для панели детальной информации
+func formatDepartmentSummary(node *OrgNode, entries []LDAPEntry) string {
+	// Переговорные, общие ящики и т.п. в сводку не попадают
+	var people []LDAPEntry
+	for _, entry := range entries {
+		if entry.Type == "" {
+			people = append(people, entry)
+		}
+	}
+	entries = people
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", node.Name)
+	if node.Total < 0 {
+		fmt.Fprintf(&b, "Сотрудников: %d", len(entries))
+	} else {
+		fmt.Fprintf(&b, "Сотрудников: %d", node.Total)
+	}
+	if len(node.Children) > 0 && node.Total >= 0 {
+		fmt.Fprintf(&b, " (в самом отделе: %d)", node.Direct)
+	}
+	b.WriteString("\n")
+
+	if head := findDepartmentHead(entries); head != "" {
+		fmt.Fprintf(&b, "Руководитель: %s\n", head)
+	}
+	if prefix := commonPhonePrefix(entries); prefix != "" {
+		fmt.Fprintf(&b, "Телефоны: %s...\n", prefix)
+	}
+
+	locations := map[string]int{}
+	var emails []string
+	for _, entry := range entries {
+		if entry.L != "" {
+			locations[entry.L]++
+		}
+		if entry.Mail != "" {
+			emails = append(emails, entry.Mail)
+		}
+	}
+	if len(locations) > 0 {
+		var names []string
+		for l, n := range locations {
+			names = append(names, fmt.Sprintf("%s (%d)", l, n))
+		}
+		sort.Strings(names)
+		fmt.Fprintf(&b, "Города: %s\n", strings.Join(names, ", "))
+	}
+	if len(emails) > 0 {
+		fmt.Fprintf(&b, "Email: %s\n", strings.Join(emails, ", "))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gotk3/gotk3/gtk"
+)
+
+var (
+	// Текст фильтра дерева
+	treeFilterText string
+	// Ключи развернутых узлов дерева до начала фильтрации, nil если фильтр не задан
+	savedExpansion map[string]bool
+)
+
+// applyTreeFilter оставляет в дереве только узлы, название которых содержит
+// текст фильтра, вместе с их родителями. Вызывается только из основного потока GTK
+func applyTreeFilter(text string) {
+	store := treeStore()
+	if store == nil {
+		return
+	}
+
+	// Запоминаем развернутые узлы, чтобы вернуть их после очистки фильтра
+	if strings.TrimSpace(text) != "" && savedExpansion == nil {
+		savedExpansion = make(map[string]bool)
+		expandedRows(store, nil, savedExpansion)
+	}
+
+	treeFilterText = text
+	refreshTree(store)
+}
+
+// refreshTree заполняет дерево из orgTrees с учетом фильтра.
+// Вызывается только из основного потока GTK
+func refreshTree(store *gtk.TreeStore) {
+	store.Clear()
+
+	text := strings.ToLower(strings.TrimSpace(treeFilterText))
+	if text == "" {
+		for _, root := range orgTrees {
+			populateTreeStore(store, nil, root)
+		}
+
+		if savedExpansion != nil {
+			expandRows(store, nil, savedExpansion)
+			savedExpansion = nil
+			return
+		}
+
+		// Раскрытие первого уровня
+		iter, ok := store.GetIterFirst()
+		for ok {
+			path, _ := store.GetPath(iter)
+			treeView.ExpandRow(path, false)
+			ok = store.IterNext(iter)
+		}
+		return
+	}
+
+	ancestors := make(map[string]bool)
+	roots := filterOrgTrees(text, ancestors)
+	if len(roots) == 0 {
+		// Повторяем в другой раскладке клавиатуры
+		if converted := strings.ToLower(ConvertString(text)); converted != "" {
+			roots = filterOrgTrees(converted, ancestors)
+		}
+	}
+
+	for _, root := range roots {
+		populateTreeStore(store, nil, root)
+	}
+	expandRows(store, nil, ancestors)
+}
+
+// filterOrgTrees фильтрует деревья всех источников
+func filterOrgTrees(text string, ancestors map[string]bool) []*OrgNode {
+	var roots []*OrgNode
+	for _, root := range orgTrees {
+		if node := filterOrgNode(root, text, nil, ancestors); node != nil {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// filterOrgNode возвращает копию узла только с подходящими под фильтр ветвями
+// или nil, если подходящих узлов нет. Подходящий узел остается со всеми
+// вложенными, а ключи его родителей добавляются в ancestors для разворачивания
+func filterOrgNode(node *OrgNode, text string, parents []string, ancestors map[string]bool) *OrgNode {
+	if strings.Contains(strings.ToLower(node.Name), text) {
+		for _, key := range parents {
+			ancestors[key] = true
+		}
+		return node
+	}
+
+	keys := append(append([]string{}, parents...), node.Key)
+	filtered := *node
+	filtered.Children = make(map[string]*OrgNode)
+	for name, child := range node.Children {
+		if c := filterOrgNode(child, text, keys, ancestors); c != nil {
+			filtered.Children[name] = c
+		}
+	}
+	if len(filtered.Children) == 0 {
+		return nil
+	}
+	return &filtered
+}
+
+// expandedRows собирает ключи развернутых узлов дерева
+func expandedRows(store *gtk.TreeStore, parent *gtk.TreeIter, result map[string]bool) {
+	var iter gtk.TreeIter
+	for ok := store.IterChildren(parent, &iter); ok; ok = store.IterNext(&iter) {
+		path, err := store.GetPath(&iter)
+		if err != nil || !treeView.RowExpanded(path) {
+			continue
+		}
+		result[getKeyIter(store, &iter)] = true
+		expandedRows(store, &iter, result)
+	}
+}
+
+// expandRows разворачивает узлы дерева с заданными ключами
+func expandRows(store *gtk.TreeStore, parent *gtk.TreeIter, keys map[string]bool) {
+	var iter gtk.TreeIter
+	for ok := store.IterChildren(parent, &iter); ok; ok = store.IterNext(&iter) {
+		if !keys[getKeyIter(store, &iter)] {
+			continue
+		}
+		if path, err := store.GetPath(&iter); err == nil {
+			treeView.ExpandRow(path, false)
+		}
+		expandRows(store, &iter, keys)
+	}
+}
+
+// treeStore возвращает модель дерева организаций
+func treeStore() *gtk.TreeStore {
+	model, err := treeView.GetModel()
+	if err != nil {
+		return nil
+	}
+	store, _ := model.(*gtk.TreeStore)
+	return store
+}